@@ -0,0 +1,257 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchFlusher 使用 batchWriter 的输出，flushBatch 同步发送缓存队列，见 batchWriter.flush
+type batchFlusher interface {
+	flushBatch(timeout time.Duration)
+}
+
+// batchWriter 缓存队列、批量发送、流量控制和失败写文件重发。
+// syslog、elasticsearch 等输出共用这套逻辑，只需要提供 send 函数。
+type batchWriter struct {
+	name    string   // 输出名称，用于错误提示
+	daemon  int32    //后台协程是否运行，1为运行，原子读写
+	stopTag chan int //发送协程
+
+	buff *queue //缓存队列
+
+	limit     chan int
+	waitGroup *inflight          //并发控制，flush 等待时后台协程可以继续发送
+	flushReq  chan chan struct{} // flush 请求，后台协程发出当前批次和队列中剩余的记录后关闭收到的 channel
+	filePath  string             //缓存文件
+	batchSize int                // 批发条数
+	linger    int64              //延时等待时间
+
+	// send 发送一批以换行分隔的记录，返回错误时整批写入缓存文件
+	send func(b []byte) error
+
+	closeMu sync.RWMutex // 保护 closed，close 等待正在进行的 put 结束
+	closed  bool
+}
+
+func newBatchWriter(name, filePath string, batchSize int, linger int64, send func(b []byte) error) (*batchWriter, error) {
+	w := &batchWriter{
+		name:      name,
+		buff:      NewQueue(100000, time.Millisecond*10),
+		daemon:    1,
+		stopTag:   make(chan int),
+		limit:     make(chan int, 30),
+		waitGroup: newInflight(),
+		flushReq:  make(chan chan struct{}),
+		filePath:  strings.TrimSuffix(filePath, "/"),
+		batchSize: batchSize,
+		linger:    linger,
+		send:      send,
+	}
+	if w.batchSize <= 0 {
+		w.batchSize = 1000
+	}
+	if w.linger <= 0 {
+		w.linger = 3
+	}
+	err := os.MkdirAll(w.filePath, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (S *batchWriter) start() {
+	go S.scanBuffer()
+}
+
+// put 放入缓存队列。关闭后写入的记录(如重新加载配置时仍在写旧输出的协程)直接写入缓存文件，
+// 由使用同一缓存目录的新输出重发
+func (S *batchWriter) put(msg string) {
+	S.closeMu.RLock()
+	defer S.closeMu.RUnlock()
+	if S.closed {
+		S.writeFile([]byte(msg))
+		return
+	}
+	S.buff.Put(msg)
+}
+
+// close 停止后台协程，发送缓存队列中剩余的记录并等待发送结束，重复调用时直接返回
+func (S *batchWriter) close() {
+	S.closeMu.Lock()
+	if S.closed {
+		S.closeMu.Unlock()
+		return
+	}
+	S.closed = true
+	S.closeMu.Unlock()
+
+	atomic.StoreInt32(&S.daemon, 0)
+	<-S.stopTag
+	S.drain()
+	S.waitGroup.Wait() //等待所有发送结束
+	S.buff.Close()
+}
+
+// flush 同步发送缓存队列中的记录，包括后台协程正在等待 linger 的批次，最多等待 timeout。
+// 用于进程退出前(如 FATAL)发送记录，输出仍然可以继续使用
+func (S *batchWriter) flush(timeout time.Duration) {
+	S.closeMu.RLock()
+	defer S.closeMu.RUnlock()
+	if S.closed {
+		return
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ack := make(chan struct{})
+	select {
+	case S.flushReq <- ack:
+	case <-timer.C:
+		fmt.Fprintln(os.Stderr, S.name, "flush timeout", timeout)
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ack
+		S.waitGroup.Wait()
+	}()
+	select {
+	case <-done:
+	case <-timer.C:
+		fmt.Fprintln(os.Stderr, S.name, "flush timeout", timeout)
+	}
+}
+
+// drain 按批次同步发送缓存队列中剩余的记录
+func (S *batchWriter) drain() {
+	count := 0
+	buff := new(bytes.Buffer)
+	for !S.buff.Empty() {
+		content, ok := S.buff.Get()
+		if ok {
+			buff.WriteString(content.(string))
+			count++
+		}
+		if count >= S.batchSize {
+			S.waitGroup.Add(1)
+			S.emit(buff.Bytes())
+			buff.Reset()
+			count = 0
+		}
+	}
+	if buff.Len() > 0 {
+		S.waitGroup.Add(1)
+		S.emit(buff.Bytes())
+	}
+}
+
+func (S *batchWriter) scanBuffer() {
+	defer func() {
+		S.stopTag <- 1
+	}()
+	start := time.Now().Unix()
+	count := 0
+	for atomic.LoadInt32(&S.daemon) == 1 {
+		buff := new(bytes.Buffer)
+		var ack chan struct{}
+		// 关闭时不再等待 linger，剩余的记录由 close 发送；flush 时立即发出当前批次
+		for atomic.LoadInt32(&S.daemon) == 1 && ack == nil && count < S.batchSize && time.Now().Unix()-start < S.linger {
+			select {
+			case ack = <-S.flushReq:
+				continue
+			default:
+			}
+			content, ok := S.buff.Get()
+			if ok {
+				buff.WriteString(content.(string))
+				count++
+			}
+		}
+		if count > 0 {
+			S.waitGroup.Add(1)
+			go S.emit(buff.Bytes())
+		}
+		if ack != nil {
+			// 由后台协程发送队列中剩余的记录，避免 flush 返回后后台协程才取出记录，等待 linger 后才发出
+			S.drain()
+			close(ack)
+		}
+		if count < S.batchSize {
+			S.scanFile()
+		}
+		count = 0
+		start = time.Now().Unix()
+	}
+}
+
+func (S *batchWriter) emit(b []byte) {
+	defer S.waitGroup.Add(-1)
+	select {
+	case S.limit <- 1:
+		defer func() {
+			<-S.limit
+		}()
+		if err := S.send(b); err != nil {
+			fmt.Fprintln(os.Stderr, S.name, "send fail, write file", err)
+			S.writeFile(b)
+		}
+
+	case <-time.After(time.Millisecond * 10):
+		fmt.Fprintln(os.Stderr, "flow control, write file")
+		S.writeFile(b)
+	}
+	return
+}
+
+func (S *batchWriter) writeFile(data []byte) {
+	//f := getRandomString(32)
+	fileName := fmt.Sprintf("%s/%d", S.filePath, time.Now().UnixNano())
+	err := ioutil.WriteFile(fileName, data, os.ModePerm)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return
+}
+
+func (S *batchWriter) scanFile() {
+	filePath := S.filePath
+	files, err := ioutil.ReadDir(filePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	if len(files) == 0 {
+		return
+	}
+	n := rand.Intn(len(files))
+	name := files[n].Name()
+	fileName := filePath + "/" + name
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(content) > 0 {
+		S.waitGroup.Add(1)
+		go S.emit(content)
+	}
+	os.Remove(fileName)
+}
+
+// splitLines 把一批记录按换行拆分，忽略空行
+func splitLines(b []byte) [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(b, []byte{'\n'}) {
+		if len(bytes.TrimSpace(line)) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,11 @@
+//go:build !go1.18
+// +build !go1.18
+
+package navi_go_log
+
+import "runtime/debug"
+
+// vcsRevision Go 1.18 以前的构建信息中没有 vcs.revision，commit 只能通过 BuildCommit 注入
+func vcsRevision(info *debug.BuildInfo) string {
+	return ""
+}
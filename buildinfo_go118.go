@@ -0,0 +1,16 @@
+//go:build go1.18
+// +build go1.18
+
+package navi_go_log
+
+import "runtime/debug"
+
+// vcsRevision 返回构建信息中的 vcs.revision，不在版本库中构建或使用 -buildvcs=false 时为空
+func vcsRevision(info *debug.BuildInfo) string {
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package navi_go_log
+
+import (
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// callerInfo 由程序计数器解析出的调用信息
+type callerInfo struct {
+	file         string // 相对模块根目录的文件路径，用于栈信息
+	fileName     string // 文件名，用于 filename 字段
+	module       string // 包导入路径
+	funcName     string // 不含包路径的函数名
+	funcFullName string // 函数全名
+	line         int
+	std          bool // 是否为 runtime 或标准库的栈帧
+}
+
+// callerCache 以程序计数器为 key 缓存解析结果，同一调用点只需要解析一次。
+// 程序计数器的数量受代码大小限制，不需要淘汰。
+var callerCache sync.Map // map[uintptr]*callerInfo
+
+// resolveCaller 解析 runtime.Callers 返回的程序计数器(返回地址)，结果会被缓存
+func resolveCaller(pc uintptr) *callerInfo {
+	if v, ok := callerCache.Load(pc); ok {
+		return v.(*callerInfo)
+	}
+	info := resolveCallerNoCache(pc)
+	if info != nil {
+		callerCache.Store(pc, info)
+	}
+	return info
+}
+
+func resolveCallerNoCache(pc uintptr) *callerInfo {
+	// 内联函数的程序计数器需要通过 CallersFrames 解析，FuncForPC 会得到错误的函数和行号
+	frame, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	if frame.Function == "" {
+		return nil
+	}
+	file, line, name := frame.File, frame.Line, frame.Function
+	_, fileName := filepath.Split(file)
+	return &callerInfo{
+		file:         TrimModulePath(file, name),
+		fileName:     fileName,
+		module:       funcPackage(name),
+		funcName:     StripPackage(name),
+		funcFullName: name,
+		line:         line,
+		std:          isStdFrame(name, file),
+	}
+}
+
+func (c *callerInfo) frame() Frame {
+	return Frame{
+		File: c.file,
+		Line: c.line,
+		Name: c.funcName,
+	}
+}
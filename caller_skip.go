@@ -0,0 +1,78 @@
+package navi_go_log
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// 被 Helper 标记的函数全名，获取调用信息时跳过这些函数
+var helperFuncs sync.Map // map[string]struct{}
+
+// 最多跳过的辅助函数层数
+const maxHelperDepth = 16
+
+// 已标记的函数数量，为 0 时获取调用信息不需要检查
+var helperCount int32
+
+// Helper 把调用它的函数标记为日志辅助函数，用法同 testing.T.Helper。
+// 获取文件名、行号和栈信息时会跳过调用栈顶部连续的辅助函数，
+// 记录的是调用辅助函数的位置，多层封装时也不需要调整 LogCallDepth。
+func Helper() {
+	markHelper(2)
+}
+
+// Helper 同包级的 Helper，方便在持有 logger 的封装函数中调用
+func (logger *CustomLogger) Helper() {
+	markHelper(2)
+}
+
+func markHelper(skip int) {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+1, pcs[:]) == 0 {
+		return
+	}
+	info := resolveCaller(pcs[0])
+	if info == nil {
+		return
+	}
+	if _, loaded := helperFuncs.LoadOrStore(info.funcFullName, struct{}{}); !loaded {
+		atomic.AddInt32(&helperCount, 1)
+	}
+}
+
+// hasHelpers 是否有函数被 Helper 标记
+func hasHelpers() bool {
+	return atomic.LoadInt32(&helperCount) > 0
+}
+
+// skipHelperPCs 去掉调用栈顶部连续的辅助函数，至少保留一层
+func skipHelperPCs(pcs []uintptr) []uintptr {
+	if !hasHelpers() {
+		return pcs
+	}
+	for len(pcs) > 1 {
+		info := resolveCaller(pcs[0])
+		if info == nil {
+			break
+		}
+		if _, ok := helperFuncs.Load(info.funcFullName); !ok {
+			break
+		}
+		pcs = pcs[1:]
+	}
+	return pcs
+}
+
+// WithCallerSkip 返回一个额外跳过 n 层调用的派生 logger，用于封装日志函数，
+// 不影响全局的 SetLogCallDepth 和其他 logger。
+// 派生 logger 与原 logger 共享等级、输出、TAG 等全部配置，只有跳过的层数是独立的：
+// 原 logger 的 InitLogger、SetLevel 等修改对派生 logger 立即生效，不需要重新派生；
+// 在派生 logger 上调用 Set 方法同样会修改原 logger。InitLogger 和 WriterClose 请在原 logger 上调用。
+func (logger *CustomLogger) WithCallerSkip(n int) *CustomLogger {
+	logger.mu.Lock()
+	derived := *logger
+	logger.mu.Unlock()
+	derived.callerSkip += n
+	return &derived
+}
@@ -0,0 +1,143 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// 输出类型
+const (
+	SinkSyslog  = "syslog"
+	SinkFile    = "file"
+	SinkHttp    = "http"
+	SinkGelf    = "gelf"
+	SinkElastic = "elastic"
+	SinkLoki    = "loki"
+)
+
+// SinkConfig 配置文件中的一个输出，Type 决定使用哪一项配置
+type SinkConfig struct {
+	Type    string         `json:"type" yaml:"type"` // syslog、file、http、gelf、elastic 或 loki
+	Syslog  *SyslogConfig  `json:"syslog,omitempty" yaml:"syslog,omitempty"`
+	File    *FileConfig    `json:"file,omitempty" yaml:"file,omitempty"`
+	Http    *HttpConfig    `json:"http,omitempty" yaml:"http,omitempty"`
+	Gelf    *GelfConfig    `json:"gelf,omitempty" yaml:"gelf,omitempty"`
+	Elastic *ElasticConfig `json:"elastic,omitempty" yaml:"elastic,omitempty"`
+	Loki    *LokiConfig    `json:"loki,omitempty" yaml:"loki,omitempty"`
+	// 类型和配置相同的输出由所有 logger 共享，Instance 不同时各自创建，未设置时使用 LoggerConfig.SinkInstance
+	Instance string `json:"instance,omitempty" yaml:"instance,omitempty"`
+}
+
+// LoadConfig 从 JSON(.json) 或 YAML(.yaml、.yml) 文件读取日志配置，不认识的字段会报错。
+// 配置项的优先级从高到低为：环境变量、配置文件、默认值，环境变量在 InitLogger 时覆盖。
+// 同一仓库的多个服务可以共用一个配置文件，再各自设置 LoggerName：
+//
+//	conf, err := nLog.LoadConfig("log.yaml")
+//	conf.LoggerName = "data_transfer"
+//	nLog.Logger.InitLogger(conf)
+func LoadConfig(path string) (*LoggerConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	conf := &LoggerConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(conf)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, conf)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q, use .json, .yaml or .yml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load config %s: %v", path, err)
+	}
+
+	// 记录文件中出现的配置项，EffectiveConfig 据此区分来源
+	var raw map[string]interface{}
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		err = json.Unmarshal(content, &raw)
+	} else {
+		err = yaml.Unmarshal(content, &raw)
+	}
+	if err == nil {
+		keys := make(map[string]bool)
+		flattenKeys("", raw, keys)
+		conf.fileValues = make(map[string]string)
+		for _, s := range settings {
+			if keys[s.key] {
+				conf.fileValues[s.key] = s.get(conf)
+			}
+		}
+	}
+	return conf, nil
+}
+
+// flattenKeys 把嵌套的字段名用 . 连接，如 syslog.batch_size
+func flattenKeys(prefix string, value interface{}, keys map[string]bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			keys[prefix+k] = true
+			flattenKeys(prefix+k+".", child, keys)
+		}
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			name := fmt.Sprint(k)
+			keys[prefix+name] = true
+			flattenKeys(prefix+name+".", child, keys)
+		}
+	}
+}
+
+// dialSink 获取配置文件中的一个输出，配置相同的输出从注册表共享
+func dialSink(sink SinkConfig, loggerConfig *LoggerConfig) (LogHandle, error) {
+	if err := sink.validate(); err != nil {
+		return nil, err
+	}
+	instance := sink.Instance
+	if instance == "" {
+		instance = loggerConfig.SinkInstance
+	}
+	var conf interface{}
+	var dial func() (LogHandle, error)
+	switch sink.Type {
+	case SinkSyslog:
+		syslogConf := *sink.Syslog
+		if syslogConf.Level == "" {
+			syslogConf.Level = loggerConfig.LogLevel
+		}
+		level, _ := ParseLevel(syslogConf.Level)
+		syslogConf.Level = level.String()
+		if syslogConf.BufferPath == "" {
+			// 每个 syslog 输出使用单独的缓存目录，重发时不会发到其他地址
+			syslogConf.BufferPath = SyslogConfig{}.withEnvDefaults().BufferPath + "_" + strings.NewReplacer(":", "_", "/", "_").Replace(syslogConf.Addr)
+		}
+		conf = syslogConf
+		dial = func() (LogHandle, error) { return DialSyslog(syslogConf) }
+	case SinkFile:
+		conf = *sink.File
+		dial = func() (LogHandle, error) { return OpenFile(*sink.File) }
+	case SinkHttp:
+		conf = *sink.Http
+		dial = func() (LogHandle, error) { return DialHttp(*sink.Http) }
+	case SinkGelf:
+		conf = *sink.Gelf
+		dial = func() (LogHandle, error) { return DialGelf(*sink.Gelf) }
+	case SinkElastic:
+		conf = *sink.Elastic
+		dial = func() (LogHandle, error) { return DialElastic(*sink.Elastic) }
+	default: // SinkLoki，其他类型已被 validate 拒绝
+		conf = *sink.Loki
+		dial = func() (LogHandle, error) { return DialLoki(*sink.Loki) }
+	}
+	return acquireSink(sinkKey(sink.Type, instance, conf), dial)
+}
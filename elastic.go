@@ -0,0 +1,204 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ElasticConfig 直接写入 Elasticsearch _bulk 接口的配置
+type ElasticConfig struct {
+	Url         string `json:"url,omitempty" yaml:"url,omitempty"`                   // Elasticsearch 地址，如 http://192.168.26.100:9200
+	IndexPrefix string `json:"index_prefix,omitempty" yaml:"index_prefix,omitempty"` // 索引前缀，默认使用记录中的 @global_tag
+	IndexDate   string `json:"index_date,omitempty" yaml:"index_date,omitempty"`     // 索引日期格式(Go时间格式)，默认 2006.01.02
+	Username    string `json:"username,omitempty" yaml:"username,omitempty"`         // basic auth 用户名
+	Password    string `json:"password,omitempty" yaml:"password,omitempty"`         // basic auth 密码
+	BatchSize   int    `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`     // 批发条数，默认 1000
+	Linger      int64  `json:"linger,omitempty" yaml:"linger,omitempty"`             // 延时等待时间(秒)，默认 3
+	MaxRetries  int    `json:"max_retries,omitempty" yaml:"max_retries,omitempty"`   // 部分失败时的重试次数，默认 3
+	Timeout     int    `json:"timeout,omitempty" yaml:"timeout,omitempty"`           // 请求超时时间(毫秒)，默认 3000
+	BufferPath  string `json:"buffer_path,omitempty" yaml:"buffer_path,omitempty"`   // 发送失败时的缓存目录，默认 /data/elastic_buffer
+}
+
+// ElasticHandle 批量写入 Elasticsearch 的输出，发送失败时写入本地缓存文件并定时重发
+type ElasticHandle struct {
+	conf   ElasticConfig
+	client *http.Client
+	batch  *batchWriter
+}
+
+// bulk 接口返回中每条记录的结果
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// DialElastic 创建 Elasticsearch 输出
+func DialElastic(conf ElasticConfig) (*ElasticHandle, error) {
+	if conf.Url == "" {
+		return nil, errors.New("elastic url is empty")
+	}
+	conf.Url = strings.TrimSuffix(conf.Url, "/")
+	if conf.IndexDate == "" {
+		conf.IndexDate = "2006.01.02"
+	}
+	if conf.MaxRetries <= 0 {
+		conf.MaxRetries = 3
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3000
+	}
+	if conf.BufferPath == "" {
+		conf.BufferPath = "/data/elastic_buffer"
+	}
+	E := &ElasticHandle{
+		conf:   conf,
+		client: &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+	}
+	var err error
+	E.batch, err = newBatchWriter("elastic", conf.BufferPath, conf.BatchSize, conf.Linger, E.send)
+	if err != nil {
+		return nil, err
+	}
+	E.batch.start()
+	return E, nil
+}
+
+func (E *ElasticHandle) Write(b []byte) (n int, err error) {
+	return E.WriteString(string(b))
+}
+
+func (E *ElasticHandle) WriteString(msg string) (n int, err error) {
+	if !strings.HasSuffix(msg, "\n") {
+		msg = msg + "\n"
+	}
+	E.batch.put(msg)
+	return len(msg), nil
+}
+
+func (E *ElasticHandle) Close() error {
+	E.batch.close()
+	return nil
+}
+
+func (E *ElasticHandle) flushBatch(timeout time.Duration) {
+	E.batch.flush(timeout)
+}
+
+// send 发送一批记录。请求失败时返回错误，整批写入缓存文件；
+// 部分记录失败时只重试可重试的记录，重试次数用完后写入缓存文件。
+func (E *ElasticHandle) send(b []byte) error {
+	docs := splitLines(b)
+	for retry := 0; len(docs) > 0; retry++ {
+		failed, err := E.bulk(docs)
+		if err != nil {
+			if retry == 0 {
+				return err
+			}
+			// 之前的请求已经写入了一部分，只缓存剩余的记录
+			fmt.Fprintln(os.Stderr, "elastic send fail, write file", err)
+			E.batch.writeFile(bytes.Join(docs, []byte{'\n'}))
+			return nil
+		}
+		docs = failed
+		if len(docs) == 0 {
+			return nil
+		}
+		if retry >= E.conf.MaxRetries {
+			fmt.Fprintln(os.Stderr, "elastic bulk retry exhausted, write file", len(docs))
+			E.batch.writeFile(bytes.Join(docs, []byte{'\n'}))
+			return nil
+		}
+		time.Sleep(time.Duration(100<<uint(retry)) * time.Millisecond)
+	}
+	return nil
+}
+
+// bulk 调用 _bulk 接口，返回需要重试的记录
+func (E *ElasticHandle) bulk(docs [][]byte) ([][]byte, error) {
+	// 请求体不使用缓冲池：重试、重定向或超时后，transport 可能在 Do 返回后仍在读取它
+	var body bytes.Buffer
+	for _, doc := range docs {
+		body.WriteString(`{"index":{"_index":`)
+		body.Write(EncodeString(E.indexName(doc), false))
+		body.WriteString("}}\n")
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	req, err := http.NewRequest(http.MethodPost, E.conf.Url+"/_bulk", &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if E.conf.Username != "" {
+		req.SetBasicAuth(E.conf.Username, E.conf.Password)
+	}
+	resp, err := E.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("elastic bulk status %d: %s", resp.StatusCode, content)
+	}
+
+	var result elasticBulkResponse
+	if err = json.Unmarshal(content, &result); err != nil {
+		return nil, err
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	var failed [][]byte
+	for i, item := range result.Items {
+		if i >= len(docs) {
+			break
+		}
+		for _, r := range item {
+			if r.Status/100 == 2 {
+				continue
+			}
+			if r.Status == http.StatusTooManyRequests || r.Status >= 500 {
+				failed = append(failed, docs[i])
+			} else {
+				// 映射错误等无法通过重试解决，直接丢弃
+				fmt.Fprintln(os.Stderr, "elastic drop record", r.Status, r.Error.Type, r.Error.Reason)
+			}
+		}
+	}
+	return failed, nil
+}
+
+// indexName 索引名由 @global_tag(或 IndexPrefix) 加日期组成，如 data_transfer-2019.05.06
+func (E *ElasticHandle) indexName(doc []byte) string {
+	var record struct {
+		GlobalTag string      `json:"@global_tag"`
+		LogTime   interface{} `json:"log_time"`
+	}
+	json.Unmarshal(doc, &record)
+	prefix := E.conf.IndexPrefix
+	if prefix == "" {
+		prefix = record.GlobalTag
+	}
+	if prefix == "" {
+		prefix = globalConf().LoggerName
+	}
+	return strings.ToLower(prefix) + "-" + parseLogTime(record.LogTime).Format(E.conf.IndexDate)
+}
@@ -0,0 +1,305 @@
+package navi_go_log
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 配置项的来源，优先级从高到低为 env、file/code、default
+const (
+	SourceDefault = "default" // 默认值
+	SourceCode    = "code"    // 代码中传入的 LoggerConfig
+	SourceFile    = "file"    // LoadConfig 读取的配置文件
+	SourceEnv     = "env"     // 环境变量
+)
+
+// ConfigValue 一个配置项的生效值及其来源
+type ConfigValue struct {
+	Key    string // 配置文件中的字段名，嵌套字段用 . 连接，如 syslog.batch_size
+	Value  string // 生效值，密码等敏感项显示为 ******
+	Source string // default、code、file 或 env
+	Env    string // 来源为 env 时的环境变量名
+}
+
+// setting 一个配置项与环境变量的绑定
+type setting struct {
+	key  string
+	env  []string // 第一个为文档中的名称，其余为兼容的旧名称
+	def  string   // 默认值
+	flag bool     // 布尔配置项，false 也是有效值
+	// secret 为 true 时 EffectiveConfig 不显示值
+	secret bool
+	get    func(c *LoggerConfig) string
+	set    func(c *LoggerConfig, v string) error
+}
+
+func boolSetting(key string, env []string, def string, field func(c *LoggerConfig) *bool) setting {
+	return setting{
+		key: key, env: env, def: def, flag: true,
+		get: func(c *LoggerConfig) string { return strconv.FormatBool(*field(c)) },
+		set: func(c *LoggerConfig, v string) error {
+			b, err := parseEnvBool(v)
+			*field(c) = b
+			return err
+		},
+	}
+}
+
+func stringSetting(key string, env []string, def string, field func(c *LoggerConfig) *string) setting {
+	return setting{
+		key: key, env: env, def: def,
+		get: func(c *LoggerConfig) string { return *field(c) },
+		set: func(c *LoggerConfig, v string) error {
+			*field(c) = v
+			return nil
+		},
+	}
+}
+
+func intSetting(key string, env []string, def string, field func(c *LoggerConfig) *int) setting {
+	return setting{
+		key: key, env: env, def: def,
+		get: func(c *LoggerConfig) string { return strconv.Itoa(*field(c)) },
+		set: func(c *LoggerConfig, v string) error {
+			n, err := strconv.Atoi(v)
+			*field(c) = n
+			return err
+		},
+	}
+}
+
+func int64Setting(key string, env []string, def string, field func(c *LoggerConfig) *int64) setting {
+	return setting{
+		key: key, env: env, def: def,
+		get: func(c *LoggerConfig) string { return strconv.FormatInt(*field(c), 10) },
+		set: func(c *LoggerConfig, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			*field(c) = n
+			return err
+		},
+	}
+}
+
+// stringsSetting 逗号分隔的列表配置项
+func stringsSetting(key string, env []string, def string, field func(c *LoggerConfig) *[]string) setting {
+	return setting{
+		key: key, env: env, def: def,
+		get: func(c *LoggerConfig) string { return strings.Join(*field(c), ",") },
+		set: func(c *LoggerConfig, v string) error {
+			*field(c) = strings.Split(v, ",")
+			return nil
+		},
+	}
+}
+
+func secret(s setting) setting {
+	s.secret = true
+	return s
+}
+
+// settings 所有配置项，InitLogger 按此表读取环境变量，EffectiveConfig 按此表输出
+var settings = []setting{
+	boolSetting("to_stdout", []string{"LOG_TO_STDOUT"}, "true", func(c *LoggerConfig) *bool { return &c.ToStdout }),
+	stringSetting("stdout_format", []string{"STDOUT_FORMAT"}, "json", func(c *LoggerConfig) *string { return &c.StdoutFormat }),
+	boolSetting("simple_log", []string{"SIMPLE_LOG_ON"}, "false", func(c *LoggerConfig) *bool { return &c.SimpleLogStatus }),
+	boolSetting("propagate", nil, "false", func(c *LoggerConfig) *bool { return &c.Propagate }),
+	stringSetting("log_level", []string{"LOG_LEVEL", "LOG_OUT_LEVEL"}, "INFO", func(c *LoggerConfig) *string { return &c.LogLevel }),
+	stringSetting("logger_name", []string{"LOGGER_NAME"}, "log_test", func(c *LoggerConfig) *string { return &c.LoggerName }),
+
+	boolSetting("to_syslog", []string{"LOG_TO_ELASTIC", "LOG_TO_SYSLOG"}, "false", func(c *LoggerConfig) *bool { return &c.ToElastic }),
+	stringSetting("log_server_ip", []string{"LOG_SERVER_IP"}, "", func(c *LoggerConfig) *string { return &c.LogServerIp }),
+	stringSetting("log_server_port", []string{"LOG_SERVER_PORT"}, "", func(c *LoggerConfig) *string { return &c.LogServerPort }),
+	stringSetting("syslog.buffer_path", []string{"SYSLOG_BUFFER"}, "/data/syslog_buffer", func(c *LoggerConfig) *string { return &c.Syslog.BufferPath }),
+	intSetting("syslog.batch_size", []string{"SYSLOG_BATCH_SIZE", "BATCH_SIZE"}, "1000", func(c *LoggerConfig) *int { return &c.Syslog.BatchSize }),
+	int64Setting("syslog.linger", []string{"SYSLOG_LINGER", "Linger"}, "3", func(c *LoggerConfig) *int64 { return &c.Syslog.Linger }),
+	intSetting("syslog.timeout", []string{"SYSLOG_TIMEOUT"}, "3000", func(c *LoggerConfig) *int { return &c.Syslog.Timeout }),
+	int64Setting("syslog.conn_life_time", []string{"SYSLOG_CONN_LIFE_TIME"}, "100", func(c *LoggerConfig) *int64 { return &c.Syslog.ConnLifeTime }),
+
+	boolSetting("to_gelf", []string{"LOG_TO_GELF"}, "false", func(c *LoggerConfig) *bool { return &c.ToGelf }),
+	stringSetting("gelf.network", []string{"GELF_NETWORK"}, "udp", func(c *LoggerConfig) *string { return &c.Gelf.Network }),
+	stringSetting("gelf.addr", []string{"GELF_SERVER_ADDR"}, "", func(c *LoggerConfig) *string { return &c.Gelf.Addr }),
+	stringSetting("gelf.compress", []string{"GELF_COMPRESS"}, "gzip", func(c *LoggerConfig) *string { return &c.Gelf.Compress }),
+	intSetting("gelf.chunk_size", []string{"GELF_CHUNK_SIZE"}, "1420", func(c *LoggerConfig) *int { return &c.Gelf.ChunkSize }),
+	stringSetting("gelf.host", []string{"GELF_HOST"}, "", func(c *LoggerConfig) *string { return &c.Gelf.Host }),
+
+	boolSetting("to_elastic_bulk", []string{"LOG_TO_ELASTIC_BULK"}, "false", func(c *LoggerConfig) *bool { return &c.ToElasticBulk }),
+	stringSetting("elastic_bulk.url", []string{"ELASTIC_URL"}, "", func(c *LoggerConfig) *string { return &c.ElasticBulk.Url }),
+	stringSetting("elastic_bulk.username", []string{"ELASTIC_USERNAME"}, "", func(c *LoggerConfig) *string { return &c.ElasticBulk.Username }),
+	secret(stringSetting("elastic_bulk.password", []string{"ELASTIC_PASSWORD"}, "", func(c *LoggerConfig) *string { return &c.ElasticBulk.Password })),
+	stringSetting("elastic_bulk.index_prefix", []string{"ELASTIC_INDEX_PREFIX"}, "", func(c *LoggerConfig) *string { return &c.ElasticBulk.IndexPrefix }),
+	stringSetting("elastic_bulk.index_date", []string{"ELASTIC_INDEX_DATE"}, "2006.01.02", func(c *LoggerConfig) *string { return &c.ElasticBulk.IndexDate }),
+	intSetting("elastic_bulk.batch_size", []string{"ELASTIC_BATCH_SIZE"}, "1000", func(c *LoggerConfig) *int { return &c.ElasticBulk.BatchSize }),
+	int64Setting("elastic_bulk.linger", []string{"ELASTIC_LINGER"}, "3", func(c *LoggerConfig) *int64 { return &c.ElasticBulk.Linger }),
+	intSetting("elastic_bulk.max_retries", []string{"ELASTIC_MAX_RETRIES"}, "3", func(c *LoggerConfig) *int { return &c.ElasticBulk.MaxRetries }),
+	intSetting("elastic_bulk.timeout", []string{"ELASTIC_TIMEOUT"}, "3000", func(c *LoggerConfig) *int { return &c.ElasticBulk.Timeout }),
+	stringSetting("elastic_bulk.buffer_path", []string{"ELASTIC_BUFFER"}, "/data/elastic_buffer", func(c *LoggerConfig) *string { return &c.ElasticBulk.BufferPath }),
+
+	boolSetting("to_loki", []string{"LOG_TO_LOKI"}, "false", func(c *LoggerConfig) *bool { return &c.ToLoki }),
+	stringSetting("loki.url", []string{"LOKI_URL"}, "", func(c *LoggerConfig) *string { return &c.Loki.Url }),
+	stringSetting("loki.tenant_id", []string{"LOKI_TENANT_ID"}, "", func(c *LoggerConfig) *string { return &c.Loki.TenantId }),
+	stringsSetting("loki.labels", []string{"LOKI_LABELS"}, "@global_tag,level_name,tag", func(c *LoggerConfig) *[]string { return &c.Loki.Labels }),
+	stringSetting("loki.username", []string{"LOKI_USERNAME"}, "", func(c *LoggerConfig) *string { return &c.Loki.Username }),
+	secret(stringSetting("loki.password", []string{"LOKI_PASSWORD"}, "", func(c *LoggerConfig) *string { return &c.Loki.Password })),
+	intSetting("loki.batch_size", []string{"LOKI_BATCH_SIZE"}, "1000", func(c *LoggerConfig) *int { return &c.Loki.BatchSize }),
+	int64Setting("loki.linger", []string{"LOKI_LINGER"}, "3", func(c *LoggerConfig) *int64 { return &c.Loki.Linger }),
+	intSetting("loki.timeout", []string{"LOKI_TIMEOUT"}, "3000", func(c *LoggerConfig) *int { return &c.Loki.Timeout }),
+	stringSetting("loki.buffer_path", []string{"LOKI_BUFFER"}, "/data/loki_buffer", func(c *LoggerConfig) *string { return &c.Loki.BufferPath }),
+
+	boolSetting("to_http", []string{"LOG_TO_HTTP"}, "false", func(c *LoggerConfig) *bool { return &c.ToHttp }),
+	stringSetting("http.url", []string{"HTTP_LOG_URL"}, "", func(c *LoggerConfig) *string { return &c.Http.Url }),
+	secret(stringSetting("http.token", []string{"HTTP_LOG_TOKEN"}, "", func(c *LoggerConfig) *string { return &c.Http.Token })),
+	boolSetting("http.gzip", []string{"HTTP_LOG_GZIP"}, "false", func(c *LoggerConfig) *bool { return &c.Http.Gzip }),
+	intSetting("http.batch_size", []string{"HTTP_LOG_BATCH_SIZE"}, "1000", func(c *LoggerConfig) *int { return &c.Http.BatchSize }),
+	int64Setting("http.linger", []string{"HTTP_LOG_LINGER"}, "3", func(c *LoggerConfig) *int64 { return &c.Http.Linger }),
+	intSetting("http.max_retries", []string{"HTTP_LOG_MAX_RETRIES"}, "3", func(c *LoggerConfig) *int { return &c.Http.MaxRetries }),
+	intSetting("http.timeout", []string{"HTTP_LOG_TIMEOUT"}, "3000", func(c *LoggerConfig) *int { return &c.Http.Timeout }),
+	stringSetting("http.buffer_path", []string{"HTTP_LOG_BUFFER"}, "/data/http_buffer", func(c *LoggerConfig) *string { return &c.Http.BufferPath }),
+
+	stringSetting("stack_format", []string{"LOG_STACK_FORMAT"}, StackFormatString, func(c *LoggerConfig) *string { return &c.StackFormat }),
+	intSetting("stack_depth", []string{"LOG_STACK_DEPTH"}, "32", func(c *LoggerConfig) *int { return &c.StackDepth }),
+	stringsSetting("metadata_fields", []string{"LOG_METADATA_FIELDS"}, "", func(c *LoggerConfig) *[]string { return &c.MetadataFields }),
+	// 以下配置项只能在代码或配置文件中设置：levels、sinks，以及 stack_policy、env_prefix、sink_instance、
+	// loki.static_labels、http.headers 等结构或映射类型的配置项，后者不在 EffectiveConfig 中输出
+	{
+		key: "levels",
+		get: func(c *LoggerConfig) string {
+			levels := make([]string, 0, len(c.Levels))
+			for name, level := range c.Levels {
+				levels = append(levels, name+"="+level)
+			}
+			sort.Strings(levels)
+			return strings.Join(levels, ",")
+		},
+	},
+	{
+		key: "sinks",
+		get: func(c *LoggerConfig) string {
+			types := make([]string, len(c.Sinks))
+			for i, sink := range c.Sinks {
+				types[i] = sink.Type
+			}
+			return strings.Join(types, ",")
+		},
+	},
+}
+
+// parseEnvBool 兼容原来的 YES/NO，也接受 true/false、1/0，不区分大小写
+func parseEnvBool(v string) (bool, error) {
+	switch strings.ToUpper(strings.TrimSpace(v)) {
+	case "YES", "TRUE", "1", "ON":
+		return true, nil
+	case "NO", "FALSE", "0", "OFF":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid bool %q, use YES or NO", v)
+}
+
+// lookupEnv 按顺序查找环境变量，设置了前缀时先查找带前缀的名称，返回找到的变量名
+func lookupEnv(prefix string, names []string) (value, name string, ok bool) {
+	if prefix != "" {
+		for _, name := range names {
+			if value, ok := os.LookupEnv(prefix + name); ok {
+				return value, prefix + name, true
+			}
+		}
+	}
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			return value, name, true
+		}
+	}
+	return "", "", false
+}
+
+// bindEnv 用环境变量覆盖配置，只处理 key 以 keyPrefix 开头的配置项，返回配置项对应的环境变量名。
+// 值为空的环境变量视为未设置。
+func bindEnv(conf *LoggerConfig, keyPrefix string) (map[string]string, error) {
+	bound := make(map[string]string)
+	var errs ConfigErrors
+	for _, s := range settings {
+		if s.set == nil || !strings.HasPrefix(s.key, keyPrefix) {
+			continue
+		}
+		value, name, ok := lookupEnv(conf.EnvPrefix, s.env)
+		if !ok || value == "" {
+			continue
+		}
+		if err := s.set(conf, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name, err))
+			continue
+		}
+		bound[s.key] = name
+	}
+	if len(errs) > 0 {
+		return bound, errs
+	}
+	return bound, nil
+}
+
+// isSet 配置项是否有非零值。没有非零值的配置项使用默认值
+func (s setting) isSet(c *LoggerConfig) bool {
+	v := s.get(c)
+	if s.flag {
+		return v == "true"
+	}
+	return v != "" && v != "0"
+}
+
+// setKeys 记录传入 InitLogger 时已经设置的配置项，在读取环境变量之前调用。
+// 这些配置项的来源为 code，与默认值相同也不例外(如 LogLevel: "INFO")
+func setKeys(conf *LoggerConfig) map[string]bool {
+	keys := make(map[string]bool)
+	for _, s := range settings {
+		if s.isSet(conf) {
+			keys[s.key] = true
+		}
+	}
+	return keys
+}
+
+// effectiveConfig 计算每个配置项的生效值和来源，env 为 bindEnv 的结果，code 为 setKeys 的结果。
+// 配置文件中出现过且之后没有在代码中修改的配置项来源为 file，即使值与默认值相同
+func effectiveConfig(conf *LoggerConfig, env map[string]string, code map[string]bool) []ConfigValue {
+	values := make([]ConfigValue, 0, len(settings))
+	for _, s := range settings {
+		v := ConfigValue{Key: s.key, Value: s.get(conf)}
+		fileValue, inFile := conf.fileValues[s.key]
+		switch {
+		case env[s.key] != "":
+			v.Source = SourceEnv
+			v.Env = env[s.key]
+		case inFile && fileValue == v.Value:
+			v.Source = SourceFile
+		case code[s.key]:
+			v.Source = SourceCode
+		default:
+			v.Source = SourceDefault
+			// 零值由各输出填充默认值，布尔配置项的零值就是生效值
+			if !s.flag && s.def != "" && !s.isSet(conf) {
+				v.Value = s.def
+			}
+		}
+		if s.secret && v.Value != "" {
+			v.Value = "******"
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// EffectiveConfig 返回 logger 最近一次 InitLogger 时每个配置项的生效值和来源
+func (logger *CustomLogger) EffectiveConfig() []ConfigValue {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	values := make([]ConfigValue, len(logger.effective))
+	copy(values, logger.effective)
+	return values
+}
+
+// EffectiveConfig 返回默认 Logger 的生效配置
+func EffectiveConfig() []ConfigValue {
+	return std().EffectiveConfig()
+}
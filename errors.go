@@ -0,0 +1,109 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// 错误链的最大长度，防止 Unwrap 形成环
+const maxErrorChain = 32
+
+// errorChain 按 errors.Unwrap 展开错误链，第一个元素为 err 本身。
+// 对于 Unwrap() []error 形式的组合错误，深度优先展开所有分支。
+func errorChain(err error) []error {
+	var chain []error
+	var walk func(e error)
+	walk = func(e error) {
+		for e != nil && len(chain) < maxErrorChain {
+			chain = append(chain, e)
+			// 接口中的 nil 指针调用 Unwrap 可能 panic，不再展开
+			if isNilError(e) {
+				return
+			}
+			if multi, ok := e.(interface{ Unwrap() []error }); ok {
+				for _, child := range multi.Unwrap() {
+					walk(child)
+				}
+				return
+			}
+			e = errors.Unwrap(e)
+		}
+	}
+	walk(err)
+	return chain
+}
+
+// errorStack 返回错误链中最内层错误自带的栈信息，没有时返回 nil。
+// 支持 StackTrace() Stack，以及 github.com/pkg/errors 等返回 []uintptr 类型(如 errors.StackTrace)的 StackTrace 方法。
+func errorStack(err error) Stack {
+	var stack Stack
+	for _, e := range errorChain(err) {
+		if s := stackOfError(e); len(s) > 0 {
+			stack = s
+		}
+	}
+	return stack
+}
+
+func stackOfError(err error) Stack {
+	if isNilError(err) {
+		return nil
+	}
+	if st, ok := err.(interface{ StackTrace() Stack }); ok {
+		return st.StackTrace()
+	}
+	method := reflect.ValueOf(err).MethodByName("StackTrace")
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return nil
+	}
+	out := method.Call(nil)[0]
+	if out.Kind() != reflect.Slice || out.Type().Elem().Kind() != reflect.Uintptr {
+		return nil
+	}
+	pcs := make([]uintptr, out.Len())
+	for i := range pcs {
+		pcs[i] = uintptr(out.Index(i).Uint())
+	}
+	return stackFromPCs(pcs)
+}
+
+// isNilError 判断 err 是否为 nil 或接口中的 nil 指针，如 var e *MyErr; var err error = e
+func isNilError(err error) bool {
+	if err == nil {
+		return true
+	}
+	v := reflect.ValueOf(err)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// errorMessage 返回错误信息，接口中的 nil 指针的 Error 方法 panic 时由 fmt 恢复，输出 <nil>
+func errorMessage(err error) string {
+	return fmt.Sprint(err)
+}
+
+// errorType 返回错误的 Go 类型，如 *errors.errorString
+func errorType(err error) string {
+	return fmt.Sprintf("%T", err)
+}
+
+// writeErrorChainJSON 把错误链写成 json 数组，如 [{"type":"*fmt.wrapError","message":"read config: EOF"}]
+func writeErrorChainJSON(b *bytes.Buffer, chain []error) {
+	b.WriteByte('[')
+	for i, e := range chain {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`{"type":`)
+		b.Write(EncodeString(errorType(e), false))
+		b.WriteString(`,"message":`)
+		b.Write(EncodeString(errorMessage(e), false))
+		b.WriteByte('}')
+	}
+	b.WriteByte(']')
+}
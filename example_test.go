@@ -1,11 +1,28 @@
 package navi_go_log
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	_ "net/http/pprof"
+	"os"
+	"runtime"
+	"runtime/debug"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -147,6 +164,9 @@ func TestOutputFormat(t *testing.T) {
 }
 
 func TestSimpleLog(t *testing.T)  {
+	exitCode := -1
+	defer SetExitFunc(SetExitFunc(func(code int) { exitCode = code }))
+	defer SetFatalPolicy(SetFatalPolicy(FatalPolicy{FlushTimeout: time.Second}))
 	Logger.InitLogger(&LoggerConfig{
 		ToStdout:        true,
 		ToElastic:       true,
@@ -163,57 +183,2053 @@ func TestSimpleLog(t *testing.T)  {
 	Error("haha")
 	Critical("haha")
 	Fatal("haha")
+	if exitCode != 1 {
+		t.Errorf("Fatal should exit with 1, got %d", exitCode)
+	}
 	Fixed("haha")
-	time.Sleep(time.Second *3)
 }
 
-func TestError(t *testing.T) {
-	logger := GetLogger("data_transfer", "")
-	logger.InitLogger(&LoggerConfig{
-		ToStdout:      true,
+func TestError(t *testing.T) {
+	logger := GetLogger("data_transfer", "")
+	logger.InitLogger(&LoggerConfig{
+		ToStdout:      true,
+		ToElastic:     true,
+		LogLevel:      "DEBUG",
+		LogServerIp:   "192.168.26.100",
+		LogServerPort: "514",
+		LoggerName:    "data_transfer",
+	})
+	t.Parallel()
+	// 自定义配置
+	// init log,if you need
+	//logger, _ := New("test", "12345")
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Error(&LogRecord{
+				Message: "message-debug",
+				ExcInfo: err.(error).Error()})
+		}
+	}()
+
+	// method 1
+	logger.Warning(&LogRecord{
+		Message: "message-warning",
+		Tag:     "test-warning",
+		TraceId: "555",
+		ExcInfo: errors.New("test warning").Error()})
+
+	logger.Critical(&LogRecord{
+		Message: "message-critical",
+		Tag:     "test-critical",
+		TraceId: "666",
+		ExcInfo: errors.New("test critical").Error()})
+	// method 2
+	logger.Log(ERROR, &LogRecord{
+		Message: "message-error-3",
+		Tag:     "test-error-3",
+		TraceId: "666"})
+	// method 3
+	logger.Error(&LogRecord{
+		Message: "message-error-4",
+		Tag:     "test-error-4",
+		TraceId: "777"})
+
+	// test panic
+	panic(errors.New("test error"))
+}
+
+func TestObserver(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+	logger.SetDefaultTag("observer-tag")
+
+	logger.Info(&LogRecord{
+		Message: "observer-info",
+		TraceId: "888"})
+	logger.Error(&LogRecord{
+		Message: "observer-error",
+		Tag:     "test-error",
+		ExcInfo: "exc info",
+		Extra: &ExtField{
+			"order_id": 42}})
+
+	if observed.Len() != 2 {
+		t.Fatalf("observed %d entries, want 2", observed.Len())
+	}
+	errs := observed.FilterLevel(ERROR).FilterTag("test-error").All()
+	if len(errs) != 1 || errs[0].Message != "observer-error" || errs[0].ExcInfo != "exc info" {
+		t.Fatalf("unexpected error entries: %+v", errs)
+	}
+	if errs[0].Filename != "example_test.go" || errs[0].LineNo <= 0 {
+		t.Errorf("unexpected caller %s:%d", errs[0].Filename, errs[0].LineNo)
+	}
+	if observed.FilterExtra("order_id", 42).Len() != 1 {
+		t.Error("FilterExtra did not match order_id")
+	}
+	if observed.FilterTraceId("888").All()[0].Tag != "observer-tag" {
+		t.Error("default tag not captured")
+	}
+
+	observed.Reset()
+	logger.Debug(&LogRecord{Message: "after reset"})
+	if entries := observed.TakeAll(); len(entries) != 1 || observed.Len() != 0 {
+		t.Errorf("TakeAll returned %d entries, %d left", len(entries), observed.Len())
+	}
+
+	// 未开启简易日志时 *f 函数的记录也会进入观察者
+	logger.SetSimpleLogStatus(false)
+	defer SetDefault(SetDefault(logger))
+	Infof("simple %d", 1)
+	Errorf("simple %d", 2)
+	entries := observed.TakeAll()
+	if len(entries) != 2 || entries[0].Message != "simple 1" || entries[1].LevelName != "ERROR" {
+		t.Fatalf("unexpected simple entries %+v", entries)
+	}
+	if entries[0].Filename != "example_test.go" || entries[0].LineNo <= 0 {
+		t.Errorf("unexpected caller %s:%d", entries[0].Filename, entries[0].LineNo)
+	}
+}
+
+func TestGelf(t *testing.T) {
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	gelf, err := DialGelf(GelfConfig{Addr: udp.LocalAddr().String(), ChunkSize: 64, Host: "gelf-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gelf.Close()
+	record := `{"@global_tag":"log_test","level_name":"ERROR","log_time":"2019-05-06T10:00:00.5+08:00",` +
+		`"filename":"a.go","line_no":12,"message":"gelf message","exc_info":"boom","id":"x1","extra-MAP":{"k":1}}` + "\n"
+	if _, err = gelf.WriteString(record); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重组分块并解压
+	chunks := make(map[byte][]byte)
+	var count byte
+	buf := make([]byte, 2048)
+	for count == 0 || len(chunks) < int(count) {
+		udp.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := udp.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(buf[:n], []byte{0x1e, 0x0f}) {
+			t.Fatalf("expected chunked message, got % x", buf[:2])
+		}
+		count = buf[11]
+		chunks[buf[10]] = append([]byte(nil), buf[12:n]...)
+	}
+	var payload []byte
+	for i := byte(0); i < count; i++ {
+		payload = append(payload, chunks[i]...)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg map[string]interface{}
+	if err = json.NewDecoder(zr).Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"version":       "1.1",
+		"host":          "gelf-test",
+		"short_message": "gelf message",
+		"full_message":  "boom",
+		"level":         float64(LOG_ERR),
+		"_global_tag":   "log_test",
+		"_level_name":   "ERROR",
+		"_filename":     "a.go",
+		"_line_no":      float64(12),
+		"_extra_id":     "x1",
+		"_extra-MAP":    `{"k":1}`,
+	}
+	for k, v := range want {
+		if msg[k] != v {
+			t.Errorf("%s = %v, want %v", k, msg[k], v)
+		}
+	}
+
+	// tcp 使用 \0 分帧
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	tcpGelf, err := DialGelf(GelfConfig{Network: "tcp", Addr: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpGelf.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	tcpGelf.WriteString(record)
+	frame, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = json.Unmarshal(frame[:len(frame)-1], &msg); err != nil || msg["short_message"] != "gelf message" {
+		t.Errorf("unexpected tcp frame %q: %v", frame, err)
+	}
+
+	// 关闭后不再重连
+	tcpGelf.Close()
+	if _, err = tcpGelf.WriteString(record); err == nil {
+		t.Errorf("expected error writing to closed tcp gelf handle")
+	}
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(50 * time.Millisecond))
+	if reconnected, err := ln.Accept(); err == nil {
+		reconnected.Close()
+		t.Errorf("closed tcp gelf handle should not reconnect")
+	}
+}
+
+func TestElasticBulk(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		if r.URL.Path != "/_bulk" || user != "elastic" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		requests = append(requests, string(body))
+		first := len(requests) == 1
+		mu.Unlock()
+		if first {
+			// 第二条记录需要重试，第三条记录无法写入
+			w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},` +
+				`{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}},` +
+				`{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}]}`))
+			return
+		}
+		w.Write([]byte(`{"errors":false,"items":[{"index":{"status":201}}]}`))
+	}))
+	defer server.Close()
+
+	bufferPath, err := ioutil.TempDir("", "elastic_buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bufferPath)
+	handle, err := DialElastic(ElasticConfig{
+		Url:        server.URL,
+		Username:   "elastic",
+		Password:   "secret",
+		Linger:     1,
+		BufferPath: bufferPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		handle.WriteString(`{"@global_tag":"Data_Transfer","log_time":"2019-05-06T10:00:00+08:00","message":"doc-` + strconv.Itoa(i) + `"}` + "\n")
+	}
+	handle.Close()
+
+	if len(requests) != 2 {
+		t.Fatalf("got %d bulk requests, want 2", len(requests))
+	}
+	if !strings.HasPrefix(requests[0], `{"index":{"_index":"data_transfer-2019.05.06"}}`+"\n") {
+		t.Errorf("unexpected bulk body %q", requests[0])
+	}
+	if strings.Count(requests[0], "doc-") != 3 || strings.Count(requests[1], "doc-") != 1 || !strings.Contains(requests[1], "doc-2") {
+		t.Errorf("unexpected retry body %q", requests[1])
+	}
+	if files, _ := ioutil.ReadDir(bufferPath); len(files) != 0 {
+		t.Errorf("%d buffer files left", len(files))
+	}
+}
+
+func TestLoki(t *testing.T) {
+	pushed := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/loki/api/v1/push" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		pushed <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	bufferPath, err := ioutil.TempDir("", "loki_buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bufferPath)
+	handle, err := DialLoki(LokiConfig{
+		Url:          server.URL,
+		TenantId:     "tenant",
+		StaticLabels: map[string]string{"env": "test"},
+		Linger:       1,
+		BufferPath:   bufferPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle.WriteString(`{"@global_tag":"log_test","level_name":"INFO","log_time":"2019-05-06T10:00:02+08:00","message":"b","tag":"t1","trace_id":"1"}`)
+	handle.WriteString(`{"@global_tag":"log_test","level_name":"INFO","log_time":"2019-05-06T10:00:01+08:00","message":"a","tag":"t1","trace_id":"2"}`)
+	handle.WriteString(`{"@global_tag":"log_test","level_name":"ERROR","log_time":"2019-05-06T10:00:03+08:00","message":"c","tag":"t1","trace_id":"3"}`)
+	handle.Close()
+
+	var push struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err = json.Unmarshal(<-pushed, &push); err != nil {
+		t.Fatal(err)
+	}
+	if len(push.Streams) != 2 {
+		t.Fatalf("got %d streams, want 2", len(push.Streams))
+	}
+	info := push.Streams[0]
+	want := map[string]string{"env": "test", "global_tag": "log_test", "level_name": "INFO", "tag": "t1"}
+	if len(info.Stream) != len(want) {
+		t.Errorf("unexpected labels %v", info.Stream)
+	}
+	for k, v := range want {
+		if info.Stream[k] != v {
+			t.Errorf("label %s = %q, want %q", k, info.Stream[k], v)
+		}
+	}
+	if len(info.Values) != 2 || !strings.Contains(info.Values[0][1], `"message":"a"`) || info.Values[0][0] >= info.Values[1][0] {
+		t.Errorf("stream values not ordered: %v", info.Values)
+	}
+}
+
+func TestHttpSink(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var lines []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Service") != "log_test" ||
+			r.Header.Get("Content-Encoding") != "gzip" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(zr)
+		lines = strings.Split(strings.TrimSpace(string(body)), "\n")
+	}))
+	defer server.Close()
+
+	bufferPath, err := ioutil.TempDir("", "http_buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bufferPath)
+	logger, _ := NewObservedLogger(DEBUG)
+	handle, err := DialHttp(HttpConfig{
+		Url:        server.URL,
+		Token:      "token",
+		Headers:    map[string]string{"X-Service": "log_test"},
+		Gzip:       true,
+		Linger:     1,
+		BufferPath: bufferPath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.SetWriter([]io.Writer{handle})
+	logger.Info(&LogRecord{Message: "http-1"})
+	logger.Warning(&LogRecord{Message: "http-2"})
+	time.Sleep(100 * time.Millisecond)
+	handle.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 || len(lines) != 2 {
+		t.Fatalf("got %d attempts and %d lines, want 2 and 2", attempts, len(lines))
+	}
+	for _, line := range lines {
+		var record map[string]interface{}
+		if err = json.Unmarshal([]byte(line), &record); err != nil || !strings.HasPrefix(record["message"].(string), "http-") {
+			t.Errorf("unexpected line %q: %v", line, err)
+		}
+	}
+}
+
+func TestHttpSinkStatus(t *testing.T) {
+	cases := []struct {
+		name     string
+		statuses []int // 按请求次序返回的状态，用完后重复最后一个
+		attempts int
+		spooled  int
+	}{
+		{"408 retried", []int{http.StatusRequestTimeout, http.StatusOK}, 2, 0},
+		{"429 spooled", []int{http.StatusTooManyRequests}, 2, 1},
+		{"409 spooled", []int{http.StatusConflict}, 2, 1},
+		{"400 dropped", []int{http.StatusBadRequest}, 1, 0},
+		{"422 dropped", []int{http.StatusUnprocessableEntity}, 1, 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&attempts, 1))
+				if n > len(c.statuses) {
+					n = len(c.statuses)
+				}
+				w.WriteHeader(c.statuses[n-1])
+			}))
+			defer server.Close()
+			bufferPath, err := ioutil.TempDir("", "http_buffer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(bufferPath)
+			handle, err := DialHttp(HttpConfig{Url: server.URL, MaxRetries: 1, BufferPath: bufferPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			handle.WriteString(`{"message":"status"}`)
+			handle.Close()
+
+			files, _ := ioutil.ReadDir(bufferPath)
+			if n := int(atomic.LoadInt32(&attempts)); n != c.attempts || len(files) != c.spooled {
+				t.Errorf("got %d attempts and %d spooled files, want %d and %d", n, len(files), c.attempts, c.spooled)
+			}
+		})
+	}
+}
+
+// chanWriter 把每条日志发送到 channel，便于等待异步写入的结果
+type chanWriter chan []byte
+
+func (w chanWriter) Write(b []byte) (int, error) {
+	w <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// nextRecord 读取下一条 json 日志
+func (w chanWriter) nextRecord(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case b := <-w:
+		record := make(map[string]interface{})
+		if err := json.Unmarshal(b, &record); err != nil {
+			t.Fatalf("invalid record %q: %v", b, err)
+		}
+		return record
+	case <-time.After(time.Second):
+		t.Fatal("no record written")
+	}
+	return nil
+}
+
+func TestStackFrames(t *testing.T) {
+	logger, _ := NewObservedLogger(DEBUG)
+	out := make(chanWriter, 1)
+	logger.SetWriter([]io.Writer{out})
+	logger.SetStackFormat(StackFormatJson)
+	logger.SetStackDepth(2)
+
+	logger.Critical(&LogRecord{Message: "stack frames"})
+	record := out.nextRecord(t)
+	if _, ok := record["stack_info"]; ok {
+		t.Error("stack_info should be replaced by stack_frames")
+	}
+	frames, ok := record["stack_frames"].([]interface{})
+	if !ok || len(frames) != 2 {
+		t.Fatalf("unexpected stack_frames %v", record["stack_frames"])
+	}
+	first := frames[0].(map[string]interface{})
+	if first["func"] != "TestStackFrames" || !strings.HasSuffix(first["file"].(string), "example_test.go") || first["line"].(float64) <= 0 {
+		t.Errorf("unexpected first frame %v", first)
+	}
+
+	logger.SetStackFormat(StackFormatBoth)
+	logger.Critical(&LogRecord{Message: "stack both"})
+	record = out.nextRecord(t)
+	if record["stack_info"] == nil || record["stack_frames"] == nil {
+		t.Errorf("expected both stack_info and stack_frames: %v", record)
+	}
+}
+
+// pkgStackError 模拟 github.com/pkg/errors 带栈信息的错误
+type pkgStackTrace []uintptr
+
+type pkgStackError struct {
+	msg   string
+	stack pkgStackTrace
+}
+
+func (e *pkgStackError) Error() string { return e.msg }
+
+func (e *pkgStackError) StackTrace() pkgStackTrace { return e.stack }
+
+func newPkgStackError(msg string) error {
+	pcs := make([]uintptr, 8)
+	n := runtime.Callers(2, pcs)
+	return &pkgStackError{msg: msg, stack: pkgStackTrace(pcs[:n])}
+}
+
+func TestErrorValue(t *testing.T) {
+	observed := NewObserver()
+	Logger.SetObserver(observed)
+	defer Logger.SetObserver(nil)
+
+	base := errors.New("connection refused")
+	err := fmt.Errorf("query orders: %w", base)
+	Error(err)
+	Warning("load failed", err)
+	entries := observed.TakeAll()
+	if len(entries) != 2 {
+		t.Fatalf("observed %d entries, want 2", len(entries))
+	}
+	if entries[0].Message != err.Error() || entries[0].Err != err || entries[0].ExcInfo != err.Error() {
+		t.Errorf("unexpected entry for error value: %+v", entries[0])
+	}
+	if entries[1].Message != "load failed" || !errors.Is(entries[1].Err, base) {
+		t.Errorf("unexpected entry for error arg: %+v", entries[1])
+	}
+
+	logger, _ := NewObservedLogger(DEBUG)
+	out := make(chanWriter, 1)
+	logger.SetWriter([]io.Writer{out})
+	logger.Error(&LogRecord{Message: "query failed", Err: err})
+	record := out.nextRecord(t)
+	if record["exc_info"] != err.Error() || record["exc_type"] != "*fmt.wrapError" {
+		t.Errorf("unexpected exc fields: %v %v", record["exc_info"], record["exc_type"])
+	}
+	chain, _ := record["exc_chain"].([]interface{})
+	if len(chain) != 2 || chain[1].(map[string]interface{})["type"] != "*errors.errorString" ||
+		chain[1].(map[string]interface{})["message"] != "connection refused" {
+		t.Errorf("unexpected exc_chain: %v", record["exc_chain"])
+	}
+	if _, ok := record["stack_info"]; ok {
+		t.Error("stack_info should not be set for errors without stack")
+	}
+
+	// 错误自带的栈信息作为 stack_info
+	logger.Warning(&LogRecord{Message: "with stack", Err: fmt.Errorf("wrap: %w", newPkgStackError("origin"))})
+	record = out.nextRecord(t)
+	if stackInfo, _ := record["stack_info"].(string); !strings.HasPrefix(stackInfo, "TestErrorValue") {
+		t.Errorf("unexpected stack_info %q", stackInfo)
+	}
+}
+
+func TestStackPolicy(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+
+	logger.Error(&LogRecord{Message: "default", ExcInfo: "exc"})
+	logger.Critical(&LogRecord{Message: "default"})
+	logger.Fixed(&LogRecord{Message: "default"})
+	entries := observed.TakeAll()
+	if entries[0].StackInfo != "" || entries[1].StackInfo == "" || entries[2].StackInfo != "" {
+		t.Errorf("default policy should only capture CRITICAL and above")
+	}
+
+	logger.SetStackPolicy(StackPolicy{Level: FATAL, OnExcInfo: true, MaxDepth: 8, SkipRuntime: true})
+	logger.Critical(&LogRecord{Message: "no exc"})
+	logger.Info(&LogRecord{Message: "exc", ExcInfo: "exc"})
+	logger.Warning(&LogRecord{Message: "err", Err: errors.New("err")})
+	entries = observed.TakeAll()
+	if entries[0].StackInfo != "" {
+		t.Error("CRITICAL below threshold should not capture stack")
+	}
+	for _, e := range entries[1:] {
+		if !strings.HasPrefix(e.StackInfo, "TestStackPolicy") {
+			t.Errorf("%s: missing stack", e.Message)
+		}
+		if strings.Contains(e.StackInfo, "tRunner") || strings.Contains(e.StackInfo, "goexit") {
+			t.Errorf("%s: runtime frames not skipped: %q", e.Message, e.StackInfo)
+		}
+	}
+
+	defer SetExitFunc(SetExitFunc(func(code int) {}))
+	logger.SetStackPolicy(StackPolicy{AllGoroutinesOnFatal: true})
+	logger.Log(FATAL, &LogRecord{Message: "fatal"})
+	if stackInfo := observed.TakeAll()[0].StackInfo; !strings.HasPrefix(stackInfo, "goroutine ") {
+		t.Errorf("expected all goroutines dump, got %q", stackInfo)
+	}
+}
+
+func TestModuleFields(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+	logger.Info(&LogRecord{Message: "module"})
+	logger.Critical(&LogRecord{Message: "module"})
+	for _, e := range observed.TakeAll() {
+		if e.Module != "github.com/yeanguzhou/navi-go-log" || e.FuncName != "TestModuleFields" {
+			t.Errorf("unexpected module %q func %q", e.Module, e.FuncName)
+		}
+	}
+
+	frame := Caller(0)
+	if frame.File != "example_test.go" || frame.Name != "TestModuleFields" {
+		t.Errorf("unexpected frame %v", frame)
+	}
+	for _, f := range Callers(0) {
+		if f.Name == "tRunner" && f.File != "testing/testing.go" {
+			t.Errorf("standard library frame not trimmed: %v", f)
+		}
+	}
+}
+
+// 封装日志的辅助函数，两层封装都调用 Helper
+func logViaHelper(logger *CustomLogger, msg string) {
+	Helper()
+	logViaHelperInner(logger, msg)
+}
+
+func logViaHelperInner(logger *CustomLogger, msg string) {
+	logger.Helper()
+	logger.Critical(&LogRecord{Message: msg})
+}
+
+func logViaWrapper(logger *CustomLogger, msg string) {
+	logger.Info(&LogRecord{Message: msg})
+}
+
+func TestCallerSkip(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+
+	_, _, line, _ := runtime.Caller(0)
+	logViaHelper(logger, "helper")
+	logViaWrapper(logger.WithCallerSkip(1), "skip")
+	logger.Info(&LogRecord{Message: "direct"})
+
+	entries := observed.TakeAll()
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	for i, e := range entries {
+		if e.Filename != "example_test.go" || e.LineNo != line+1+i || e.FuncName != "TestCallerSkip" {
+			t.Errorf("%s: unexpected caller %s:%d %s", e.Message, e.Filename, e.LineNo, e.FuncName)
+		}
+	}
+	if !strings.HasPrefix(entries[0].StackInfo, "TestCallerSkip\n") {
+		t.Errorf("helper frames not skipped in stack: %q", entries[0].StackInfo)
+	}
+	if logger.callerSkip != 0 {
+		t.Errorf("WithCallerSkip changed the original logger")
+	}
+
+	// 派生 logger 与原 logger 共享配置
+	derived := logger.WithCallerSkip(1)
+	logger.SetLevel(ERROR)
+	if derived.isEnableLog(WARNING) {
+		t.Errorf("level set on the original should apply to the derived logger")
+	}
+	derived.SetLevel(DEBUG)
+	if logger.EffectiveLevel() != DEBUG {
+		t.Errorf("level set on the derived logger should apply to the original")
+	}
+}
+
+func panicWith(v interface{}) {
+	panic(v)
+}
+
+func panicNilPointer() {
+	var m *LogRecord
+	_ = m.Message
+}
+
+// recordServer 记录收到的每条 json 日志的 message，用于检查批量输出是否发出
+type recordServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received []string
+}
+
+func newRecordServer() *recordServer {
+	s := &recordServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			var record map[string]interface{}
+			if json.Unmarshal([]byte(line), &record) == nil {
+				s.received = append(s.received, fmt.Sprint(record["message"]))
+			}
+		}
+	}))
+	return s
+}
+
+func (s *recordServer) messages() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.received...)
+}
+
+func TestRecoverAndLog(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+	cases := []struct {
+		f        func()
+		funcName string
+		excInfo  string
+	}{
+		{func() { panicWith("string value") }, "panicWith", "string value"},
+		{func() { panicWith(errors.New("error value")) }, "panicWith", "error value"},
+		{func() { panicWith(42) }, "panicWith", "42"},
+		{panicNilPointer, "panicNilPointer", "runtime error: invalid memory address or nil pointer dereference"},
+	}
+	for _, c := range cases {
+		func() {
+			defer logger.RecoverAndLog(false)
+			c.f()
+		}()
+		entries := observed.TakeAll()
+		if len(entries) != 1 {
+			t.Fatalf("expected 1 entry, got %d", len(entries))
+		}
+		e := entries[0]
+		if e.Level != CRITICAL || e.FuncName != c.funcName || e.ExcInfo != c.excInfo {
+			t.Errorf("unexpected entry level %d func %q exc %q", e.Level, e.FuncName, e.ExcInfo)
+		}
+		if !strings.HasPrefix(e.StackInfo, c.funcName+"\n") || !strings.Contains(e.StackInfo, "TestRecoverAndLog") {
+			t.Errorf("unexpected stack %q", e.StackInfo)
+		}
+	}
+
+	// 重新 panic
+	var repanicked interface{}
+	func() {
+		defer func() { repanicked = recover() }()
+		defer logger.RecoverAndLog(true)
+		panicWith("again")
+	}()
+	if repanicked != "again" || observed.Len() != 1 {
+		t.Errorf("expected repanic after logging, got %v", repanicked)
+	}
+	observed.Reset()
+
+	// 重新 panic 前同步发送批量输出的缓存队列，进程随后退出时记录不会丢失
+	server := newRecordServer()
+	defer server.Close()
+	bufferPath, err := ioutil.TempDir("", "recover_http")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bufferPath)
+	batched := GetLogger("recover.batched", "")
+	err = batched.InitLogger(&LoggerConfig{
+		LogLevel: "INFO",
+		Sinks:    []SinkConfig{{Type: SinkHttp, Http: &HttpConfig{Url: server.URL, Linger: 30, BufferPath: bufferPath}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer batched.WriterClose()
+	func() {
+		defer func() { recover() }()
+		defer batched.RecoverAndLog(true)
+		panicWith("batched")
+	}()
+	if got := server.messages(); len(got) != 1 || got[0] != "panic: batched" {
+		t.Errorf("panic record not sent before repanic: %v", got)
+	}
+
+	logger.Go(func() { panicWith("goroutine") })
+	for i := 0; i < 100 && observed.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if observed.FilterMessage("panic: goroutine").Len() != 1 {
+		t.Errorf("panic in goroutine not logged")
+	}
+}
+
+func logOrderError(logger *CustomLogger, id int) {
+	logger.Error(&LogRecord{Message: fmt.Sprintf("order %d not found, request 3f2a9c1e-77b0-4c1d-9a8e-0123456789ab", id)})
+}
+
+func TestErrorFingerprint(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+	logOrderError(logger, 1)
+	logOrderError(logger, 202)
+	logger.Error(&LogRecord{Message: "order 7 not found, request 00000000-0000-0000-0000-000000000000"})
+	logger.Warning(&LogRecord{Message: "order 7 not found"})
+	logger.Critical(&LogRecord{Message: "order 9 not found", Err: errors.New("order 9 not found")})
+
+	entries := observed.TakeAll()
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d", len(entries))
+	}
+	if entries[0].Fingerprint == "" || entries[0].Fingerprint != entries[1].Fingerprint {
+		t.Errorf("same error from the same call site should share a fingerprint: %q %q", entries[0].Fingerprint, entries[1].Fingerprint)
+	}
+	if entries[2].Fingerprint == entries[0].Fingerprint {
+		t.Errorf("different call sites should not share a fingerprint")
+	}
+	if entries[3].Fingerprint != "" || entries[4].Fingerprint == "" {
+		t.Errorf("fingerprint should only be set for ERROR and above")
+	}
+	if entries[0].StackInfo != "" {
+		t.Errorf("ERROR should not capture stack_info by default: %q", entries[0].StackInfo)
+	}
+
+	for msg, want := range map[string]string{
+		"user 42 timeout after 1.5s":              "user <n> timeout after <n>.<n>s",
+		"bad pointer 0xc000123abc":                "bad pointer <hex>",
+		"object 5f3e9a0b1c2d missing":             "object <id> missing",
+		"connection refused":                      "connection refused",
+		"id 3F2A9C1E-77B0-4C1D-9A8E-0123456789AB": "id <uuid>",
+	} {
+		if got := normalizeErrorMessage(msg); got != want {
+			t.Errorf("normalizeErrorMessage(%q) = %q, want %q", msg, got, want)
+		}
+	}
+}
+
+func produceJob(jobs chan<- context.Context) {
+	jobs <- ContextWithCallers(context.Background(), 0)
+}
+
+func consumeJob(logger *CustomLogger, ctx context.Context) {
+	logger.Error(&LogRecord{Message: "job failed", Context: ctx})
+}
+
+func TestMultiStacks(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+	jobs := make(chan context.Context, 1)
+	go produceJob(jobs)
+	consumeJob(logger, <-jobs)
+
+	m := CallersMulti(0)
+	logger.Info(&LogRecord{Message: "multi", Stacks: m})
+
+	entries := observed.TakeAll()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	stackInfo := entries[0].StackInfo
+	producer := strings.Index(stackInfo, "produceJob")
+	consumer := strings.Index(stackInfo, "(Stack 2)\nconsumeJob")
+	if producer < 0 || consumer < producer {
+		t.Errorf("expected producer stack followed by consumer stack, got %q", stackInfo)
+	}
+	if !strings.HasPrefix(entries[1].StackInfo, "TestMultiStacks") || !strings.Contains(entries[1].StackInfo, "(Stack 2)\nTestMultiStacks") {
+		t.Errorf("unexpected stack %q", entries[1].StackInfo)
+	}
+	if len(m.Stacks()) != 1 {
+		t.Errorf("record Multi should not be modified")
+	}
+
+	w := make(chanWriter, 1)
+	logger.SetWriter([]io.Writer{w})
+	logger.SetStackFormat(StackFormatJson)
+	logger.Info(&LogRecord{Message: "multi json", Stacks: m})
+	record := w.nextRecord(t)
+	stacks, ok := record["stack_multi"].([]interface{})
+	if !ok || len(stacks) != 2 {
+		t.Errorf("expected 2 stacks in stack_multi, got %v", record["stack_multi"])
+	}
+}
+
+func TestMetadataFields(t *testing.T) {
+	logger, _ := NewObservedLogger(DEBUG)
+	w := make(chanWriter, 1)
+	logger.SetWriter([]io.Writer{w})
+	if err := logger.SetMetadataFields([]string{"hostname", "unknown"}); err != NoMatchMetaField {
+		t.Errorf("expected NoMatchMetaField, got %v", err)
+	}
+	if err := logger.SetMetadataFields([]string{"hostname", "PID", "goroutine_id", "go_version"}); err != nil {
+		t.Fatal(err)
+	}
+	logger.Info(&LogRecord{Message: "metadata", Extra: &ExtField{"hostname": "overridden"}})
+	record := w.nextRecord(t)
+	if record["hostname"] != "overridden" {
+		t.Errorf("extra field should override metadata, got %v", record["hostname"])
+	}
+	if record["pid"] != float64(os.Getpid()) || record["go_version"] != runtime.Version() {
+		t.Errorf("unexpected pid %v go_version %v", record["pid"], record["go_version"])
+	}
+	if id, ok := record["goroutine_id"].(float64); !ok || id <= 0 {
+		t.Errorf("unexpected goroutine_id %v", record["goroutine_id"])
+	}
+
+	logger.SetMetadataFields(nil)
+	logger.Info(&LogRecord{Message: "no metadata"})
+	if _, ok := w.nextRecord(t)["pid"]; ok {
+		t.Errorf("metadata should be disabled")
+	}
+
+	id := "3f2a9c1e77b04c1d9a8e0123456789ab3f2a9c1e77b04c1d9a8e0123456789ab"
+	cgroups := []struct {
+		content string
+		want    string
+	}{
+		{"12:memory:/docker/" + id + "\n", id},
+		{"0::/kubepods.slice/kubepods-pod1.slice/cri-containerd-" + id + ".scope", id},
+		{"0::/user.slice/user-0.slice/session-1.scope\n", ""},
+	}
+	for _, c := range cgroups {
+		if got := containerIdFromCgroup(c.content); got != c.want {
+			t.Errorf("containerIdFromCgroup(%q) = %q, want %q", c.content, got, c.want)
+		}
+	}
+}
+
+func TestBuildVersion(t *testing.T) {
+	defer func(version, commit string) {
+		BuildVersion, BuildCommit = version, commit
+	}(BuildVersion, BuildCommit)
+	BuildVersion, BuildCommit = "", ""
+
+	// 构建信息中没有 vcs.revision 时(包括 Go 1.18 以前的版本)commit 为空
+	info := &debug.BuildInfo{Main: debug.Module{Path: "example.com/app", Version: "v1.2.3"}}
+	if version, commit := versionFromBuildInfo(info); version != "v1.2.3" || commit != "" {
+		t.Errorf("unexpected version %q commit %q", version, commit)
+	}
+	if vcsRevision(info) != "" {
+		t.Errorf("unexpected revision %q", vcsRevision(info))
+	}
+
+	// ldflags 注入的值优先
+	info.Main.Version = "(devel)"
+	BuildCommit = "abc1234"
+	if version, commit := versionFromBuildInfo(info); version != "" || commit != "abc1234" {
+		t.Errorf("unexpected version %q commit %q", version, commit)
+	}
+	BuildVersion = "v2.0.0"
+	if version, _ := versionFromBuildInfo(info); version != "v2.0.0" {
+		t.Errorf("BuildVersion should win, got %q", version)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := dir + "/logs/app.log"
+
+	yamlPath := dir + "/log.yaml"
+	ioutil.WriteFile(yamlPath, []byte(`
+log_level: DEBUG
+logger_name: config_test
+stack_format: both
+stack_policy:
+  level: error
+levels:
+  config_test: WARNING
+sinks:
+  - type: file
+    file:
+      path: `+logPath+`
+      max_size: 1
+  - type: http
+    http:
+      url: http://127.0.0.1:9/logs
+      gzip: true
+      headers:
+        X-Env: test
+`), 0644)
+	conf, err := LoadConfig(yamlPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Sinks) != 2 || conf.Sinks[1].Http == nil || !conf.Sinks[1].Http.Gzip || conf.Sinks[1].Http.Headers["X-Env"] != "test" {
+		t.Fatalf("unexpected sinks %+v", conf.Sinks)
+	}
+	if conf.StackPolicy == nil || conf.StackPolicy.Level != ERROR {
+		t.Errorf("stack_policy.level should parse level names, got %+v", conf.StackPolicy)
+	}
+
+	jsonPath := dir + "/log.json"
+	ioutil.WriteFile(jsonPath, []byte(`{"log_level":"DEBUG","levels":{"config_test":"WARNING"},"sinks":[{"type":"file","file":{"path":"`+logPath+`"}}]}`), 0644)
+	jsonConf, err := LoadConfig(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jsonConf.LogLevel != "DEBUG" || jsonConf.Sinks[0].File.Path != logPath {
+		t.Errorf("unexpected json config %+v", jsonConf)
+	}
+	// stack_policy.level 可以写等级名，原来的数字配置仍然有效
+	for content, want := range map[string]Level{
+		`{"stack_policy":{"level":"crit"}}`: CRITICAL,
+		`{"stack_policy":{"level":45}}`:     45,
+	} {
+		ioutil.WriteFile(jsonPath, []byte(content), 0644)
+		if policyConf, err := LoadConfig(jsonPath); err != nil || policyConf.StackPolicy.Level != want {
+			t.Errorf("load %s: %v", content, err)
+		}
+	}
+	ioutil.WriteFile(yamlPath, []byte("stack_policy:\n  level: 45\n"), 0644)
+	if policyConf, err := LoadConfig(yamlPath); err != nil || policyConf.StackPolicy.Level != 45 {
+		t.Errorf("load numeric yaml stack_policy.level: %v", err)
+	}
+	ioutil.WriteFile(jsonPath, []byte(`{"stack_policy":{"level":"verbose"}}`), 0644)
+	if _, err := LoadConfig(jsonPath); err == nil || !strings.Contains(err.Error(), "verbose") {
+		t.Errorf("expected error for unknown stack_policy.level, got %v", err)
+	}
+	if content, _ := json.Marshal(StackPolicy{Level: 45}); string(content) != `{"level":45}` {
+		t.Errorf("unregistered level should marshal as a number: %s", content)
+	}
+
+	ioutil.WriteFile(jsonPath, []byte(`{"log_levle":"DEBUG"}`), 0644)
+	if _, err := LoadConfig(jsonPath); err == nil {
+		t.Errorf("expected error for unknown field")
+	}
+	if _, err := LoadConfig(dir + "/log.toml"); err == nil {
+		t.Errorf("expected error for unsupported file type")
+	}
+	jsonConf.Sinks = []SinkConfig{{Type: "kafka"}}
+	if _, err := newSinks(jsonConf); err == nil {
+		t.Errorf("expected error for unknown sink type")
+	}
+
+	conf.Sinks = conf.Sinks[:1]
+	logger := GetLogger("config_test", "")
+	if err := logger.InitLogger(conf); err != nil {
+		t.Fatal(err)
+	}
+	if logger.GetLevel() != WARNING {
+		t.Errorf("expected level override WARNING, got %d", logger.GetLevel())
+	}
+	logger.Info(&LogRecord{Message: "filtered"})
+	logger.Warning(&LogRecord{Message: "to file"})
+	logger.Flush()
+	logger.WriterClose()
+
+	content, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], `"message":"to file"`) {
+		t.Errorf("unexpected file content %q", content)
+	}
+}
+
+func TestFileRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	handle, err := OpenFile(FileConfig{Path: dir + "/app.log", MaxSize: 1, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.Repeat("x", 1<<19)
+	for i := 0; i < 7; i++ {
+		handle.WriteString(line)
+	}
+	handle.Close()
+	if _, err := handle.Write([]byte("closed")); err == nil {
+		t.Errorf("expected error writing to closed file")
+	}
+	files, _ := ioutil.ReadDir(dir)
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+		if f.Size() > 1<<20+2 {
+			t.Errorf("%s exceeds max size: %d", f.Name(), f.Size())
+		}
+	}
+	if strings.Join(names, ",") != "app.log,app.log.1,app.log.2" {
+		t.Errorf("unexpected files %v", names)
+	}
+}
+
+func TestBatchWriterClose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch_buffer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sent := make(chan []byte, 10)
+	w, err := newBatchWriter("test", dir, 10, 1, func(b []byte) error {
+		sent <- b
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.start()
+	w.put("before close\n")
+	w.close()
+	w.close()
+	if b := <-sent; string(b) != "before close\n" {
+		t.Errorf("unexpected batch %q", b)
+	}
+	// 关闭后写入的记录写入缓存文件，不会 panic
+	w.put("after close\n")
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Fatalf("expected 1 buffer file, got %d", len(files))
+	}
+	content, _ := ioutil.ReadFile(dir + "/" + files[0].Name())
+	if string(content) != "after close\n" {
+		t.Errorf("unexpected buffer file content %q", content)
+	}
+}
+
+// replaceFile 写入临时文件后改名替换，WatchConfig 不会读到写了一半的配置文件
+func replaceFile(path, content string) error {
+	if err := ioutil.WriteFile(path+".tmp", []byte(content), 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := GlobalConf
+	defer ReloadConfig(&saved)
+
+	logger := GetLogger("reload_test", "")
+	path := dir + "/log.yaml"
+	ioutil.WriteFile(path, []byte("log_level: ERROR\n"), 0644)
+	reloaded := make(chan error, 10)
+	watcher, err := WatchConfig(path, 10*time.Millisecond, func(err error) {
+		reloaded <- err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Stop()
+
+	waitReload := func() error {
+		select {
+		case err := <-reloaded:
+			return err
+		case <-time.After(3 * time.Second):
+			t.Fatal("config not reloaded")
+		}
+		return nil
+	}
+
+	logPath := dir + "/app.log"
+	replaceFile(path, `
+log_level: ERROR
+levels:
+  reload_test: DEBUG
+sinks:
+  - type: file
+    file:
+      path: `+logPath+`
+`)
+	if err := waitReload(); err != nil {
+		t.Fatal(err)
+	}
+	if logger.GetLevel() != DEBUG || Logger.GetLevel() != ERROR {
+		t.Errorf("unexpected levels after reload: %d %d", logger.GetLevel(), Logger.GetLevel())
+	}
+	if GlobalConf.LoggerName != saved.LoggerName {
+		t.Errorf("LoggerName should be kept, got %q", GlobalConf.LoggerName)
+	}
+	logger.Debug(&LogRecord{Message: "after reload"})
+	logger.Flush()
+	if content, _ := ioutil.ReadFile(logPath); !strings.Contains(string(content), "after reload") {
+		t.Errorf("record not written to new sink: %q", content)
+	}
+
+	// 加载失败时保持原来的配置
+	replaceFile(path, "log_levle: INFO\n")
+	if err := waitReload(); err == nil {
+		t.Errorf("expected reload error")
+	}
+	if logger.GetLevel() != DEBUG {
+		t.Errorf("level changed after failed reload: %d", logger.GetLevel())
+	}
+
+	replaceFile(path, "log_level: WARNING\n")
+	waitReload()
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+	if err := waitReload(); err != nil {
+		t.Fatal(err)
+	}
+	// reload_test 不在 Levels 中，改为使用 root_logger 的等级
+	if logger.EffectiveLevel() != WARNING {
+		t.Errorf("unexpected level after SIGHUP reload: %d", logger.EffectiveLevel())
+	}
+}
+
+func TestReinitClearsStdout(t *testing.T) {
+	logger := GetLogger("reinit_stdout", "")
+	if err := logger.InitLogger(&LoggerConfig{LogLevel: "INFO", ToStdout: true, StdoutFormat: "custom", SimpleLogStatus: true}); err != nil {
+		t.Fatal(err)
+	}
+	if st := logger.load(); st.customStdout != os.Stdout || st.stdoutFormat != "custom" || !st.simpleLog {
+		t.Fatalf("custom stdout not set: %q %v", st.stdoutFormat, st.simpleLog)
+	}
+
+	// 改为 json 格式时不再保留定制化控制台输出
+	if err := logger.InitLogger(&LoggerConfig{LogLevel: "INFO", ToStdout: true}); err != nil {
+		t.Fatal(err)
+	}
+	if st := logger.load(); st.customStdout != nil || st.stdoutFormat != "json" {
+		t.Errorf("custom stdout kept after switching to json: %q", st.stdoutFormat)
+	}
+
+	// 关闭控制台输出
+	logger.InitLogger(&LoggerConfig{LogLevel: "INFO", ToStdout: true, StdoutFormat: "custom", SimpleLogStatus: true})
+	if err := logger.InitLogger(&LoggerConfig{LogLevel: "INFO"}); err != nil {
+		t.Fatal(err)
+	}
+	if st := logger.load(); st.customStdout != nil || st.out != nil || st.stdoutFormat != "" || st.simpleLog {
+		t.Errorf("stdout kept after disabling it: %q %v", st.stdoutFormat, st.simpleLog)
+	}
+}
+
+func TestReloadKeepsChildConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_reload_child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := GlobalConf
+	defer ReloadConfig(&saved)
+
+	childPath := dir + "/child.log"
+	child := GetLogger("reload_child", "")
+	err = child.InitLogger(&LoggerConfig{
+		LogLevel:   "INFO",
+		LoggerName: "child_svc",
+		Sinks:      []SinkConfig{{Type: SinkFile, File: &FileConfig{Path: childPath}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.WriterClose()
+
+	rootPath := dir + "/root.log"
+	err = ReloadConfig(&LoggerConfig{
+		LogLevel: "WARNING",
+		Sinks:    []SinkConfig{{Type: SinkFile, File: &FileConfig{Path: rootPath}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 子 logger 保留自己的输出和 LoggerName，只合并变化的等级
+	if child.EffectiveLevel() != WARNING {
+		t.Errorf("changed LogLevel not merged: %d", child.EffectiveLevel())
+	}
+	child.Warning(&LogRecord{Message: "child after reload"})
+	child.Flush()
+	content, _ := ioutil.ReadFile(childPath)
+	if !strings.Contains(string(content), "child after reload") || !strings.Contains(string(content), `"@global_tag":"child_svc"`) {
+		t.Errorf("child lost its own sink or LoggerName: %q", content)
+	}
+	if content, _ := ioutil.ReadFile(rootPath); strings.Contains(string(content), "child after reload") {
+		t.Errorf("child should not write to root's outputs: %q", content)
+	}
+}
+
+func TestReloadKeepsSetLevel(t *testing.T) {
+	saved := GlobalConf
+	defer ReloadConfig(&saved)
+
+	coded := GetLogger("reload_coded", "")
+	coded.SetLevel(DEBUG)
+	listed := GetLogger("reload_listed", "")
+	if err := ReloadConfig(&LoggerConfig{LogLevel: "WARNING", Levels: map[string]string{"reload_listed": "ERROR"}}); err != nil {
+		t.Fatal(err)
+	}
+	if coded.EffectiveLevel() != DEBUG || listed.EffectiveLevel() != ERROR {
+		t.Errorf("unexpected levels after reload: %d %d", coded.EffectiveLevel(), listed.EffectiveLevel())
+	}
+
+	// 从 Levels 中删除的 logger 改为使用上级的等级，代码中设置的等级不受影响
+	if err := ReloadConfig(&LoggerConfig{LogLevel: "WARNING"}); err != nil {
+		t.Fatal(err)
+	}
+	if coded.EffectiveLevel() != DEBUG || listed.EffectiveLevel() != WARNING {
+		t.Errorf("unexpected levels after removing Levels: %d %d", coded.EffectiveLevel(), listed.EffectiveLevel())
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	levels := []struct {
+		name  string
+		level Level
+	}{
+		{"info", INFO}, {"Warn", WARNING}, {"WARNING", WARNING}, {" err ", ERROR},
+		{"crit", CRITICAL}, {"panic", FATAL}, {"fatal", FATAL}, {"40", ERROR}, {"", INFO},
+	}
+	for _, c := range levels {
+		if level, err := ParseLevel(c.name); err != nil || level != c.level {
+			t.Errorf("ParseLevel(%q) = %d, %v, want %d", c.name, level, err, c.level)
+		}
+	}
+	for _, name := range []string{"verbose", "15", "INFOO"} {
+		if _, err := ParseLevel(name); !errors.Is(err, NoMatchLogLevel) {
+			t.Errorf("ParseLevel(%q) should fail with NoMatchLogLevel, got %v", name, err)
+		}
+	}
+	if syslogLevM["FATAL"] != LOG_ALERT {
+		t.Errorf("FATAL should map to LOG_ALERT")
+	}
+
+	conf := &LoggerConfig{
+		LogLevel:      "verbose",
 		ToElastic:     true,
-		LogLevel:      "DEBUG",
 		LogServerIp:   "192.168.26.100",
-		LogServerPort: "514",
-		LoggerName:    "data_transfer",
+		LogServerPort: "",
+		ToHttp:        true,
+		StackFormat:   "yaml",
+		Levels:        map[string]string{"db": "trace"},
+		Sinks:         []SinkConfig{{Type: "file"}, {Type: "kafka"}, {Type: "syslog", Syslog: &SyslogConfig{Addr: "127.0.0.1:514", Level: "warn"}}},
+	}
+	err := conf.Validate()
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 7 {
+		t.Fatalf("expected 7 config errors, got %v", err)
+	}
+	for _, want := range []string{"log_level", "log_server_port", "http.url", "stack_format", "levels[db]", "sinks[0]", "sinks[1]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	logger, _ := NewObservedLogger(ERROR)
+	logger.Name = "validate_test"
+	if err := logger.InitLogger(conf); err == nil {
+		t.Errorf("InitLogger should return validation errors")
+	}
+	if logger.GetLevel() != ERROR {
+		t.Errorf("invalid config should not change the level, got %d", logger.GetLevel())
+	}
+	if err := logger.InitLogger(&LoggerConfig{LogLevel: "warn", LoggerName: "validate_test"}); err != nil {
+		t.Fatal(err)
+	}
+	if logger.GetLevel() != WARNING {
+		t.Errorf("expected WARNING, got %d", logger.GetLevel())
+	}
+}
+
+func TestEnvConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/log.yaml"
+	ioutil.WriteFile(path, []byte("stdout_format: custom\nsyslog:\n  batch_size: 10\n"), 0644)
+
+	// 文档中的名称优先于旧名称，带前缀的名称优先于不带前缀的名称
+	envs := map[string]string{
+		"LOG_LEVEL":           "ERROR",
+		"LOG_OUT_LEVEL":       "DEBUG",
+		"MYAPP_LOG_TO_STDOUT": "no",
+		"LOG_TO_STDOUT":       "YES",
+		"Linger":              "7",
+		"HTTP_LOG_TOKEN":      "secret",
+	}
+	for k, v := range envs {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	conf, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.EnvPrefix = "MYAPP_"
+	conf.StackDepth = 16
+	logger := GetLogger("env_test", "")
+	if err := logger.InitLogger(conf); err != nil {
+		t.Fatal(err)
+	}
+	if logger.GetLevel() != ERROR || conf.ToStdout || conf.Syslog.Linger != 7 {
+		t.Errorf("env not applied: %d %v %d", logger.GetLevel(), conf.ToStdout, conf.Syslog.Linger)
+	}
+
+	want := map[string]ConfigValue{
+		"log_level":         {Key: "log_level", Value: "ERROR", Source: SourceEnv, Env: "LOG_LEVEL"},
+		"to_stdout":         {Key: "to_stdout", Value: "false", Source: SourceEnv, Env: "MYAPP_LOG_TO_STDOUT"},
+		"syslog.linger":     {Key: "syslog.linger", Value: "7", Source: SourceEnv, Env: "Linger"},
+		"http.token":        {Key: "http.token", Value: "******", Source: SourceEnv, Env: "HTTP_LOG_TOKEN"},
+		"stdout_format":     {Key: "stdout_format", Value: "custom", Source: SourceFile},
+		"syslog.batch_size": {Key: "syslog.batch_size", Value: "10", Source: SourceFile},
+		"stack_depth":       {Key: "stack_depth", Value: "16", Source: SourceCode},
+		"syslog.timeout":    {Key: "syslog.timeout", Value: "3000", Source: SourceDefault},
+	}
+	for _, v := range logger.EffectiveConfig() {
+		if w, ok := want[v.Key]; ok && v != w {
+			t.Errorf("%s: expected %+v, got %+v", v.Key, w, v)
+		}
+	}
+
+	os.Setenv("LOG_TO_GELF", "maybe")
+	defer os.Unsetenv("LOG_TO_GELF")
+	if err := logger.InitLogger(conf); err == nil || !strings.Contains(err.Error(), "LOG_TO_GELF") {
+		t.Errorf("expected invalid bool error, got %v", err)
+	}
+}
+
+func TestEnvConfigSource(t *testing.T) {
+	os.Setenv("HTTP_LOG_BATCH_SIZE", "50")
+	defer os.Unsetenv("HTTP_LOG_BATCH_SIZE")
+
+	// 代码中显式设置的值与默认值相同时来源仍为 code
+	logger := GetLogger("env_source", "")
+	conf := &LoggerConfig{LogLevel: "INFO", ToStdout: true, LoggerName: "env_source"}
+	if err := logger.InitLogger(conf); err != nil {
+		t.Fatal(err)
+	}
+	if conf.Http.BatchSize != 50 {
+		t.Errorf("HTTP_LOG_BATCH_SIZE not applied: %d", conf.Http.BatchSize)
+	}
+	want := map[string]ConfigValue{
+		"log_level":        {Key: "log_level", Value: "INFO", Source: SourceCode},
+		"to_stdout":        {Key: "to_stdout", Value: "true", Source: SourceCode},
+		"simple_log":       {Key: "simple_log", Value: "false", Source: SourceDefault},
+		"http.batch_size":  {Key: "http.batch_size", Value: "50", Source: SourceEnv, Env: "HTTP_LOG_BATCH_SIZE"},
+		"loki.buffer_path": {Key: "loki.buffer_path", Value: "/data/loki_buffer", Source: SourceDefault},
+		"gelf.chunk_size":  {Key: "gelf.chunk_size", Value: "1420", Source: SourceDefault},
+	}
+	got := make(map[string]bool)
+	for _, v := range logger.EffectiveConfig() {
+		if w, ok := want[v.Key]; ok {
+			got[v.Key] = true
+			if v != w {
+				t.Errorf("%s: expected %+v, got %+v", v.Key, w, v)
+			}
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("missing settings in EffectiveConfig: %v", got)
+	}
+
+	// 配置文件中的值与默认值相同时来源为 file
+	dir, err := ioutil.TempDir("", "log_env_source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/log.yaml"
+	ioutil.WriteFile(path, []byte("log_level: INFO\nloki:\n  timeout: 3000\n"), 0644)
+	conf, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := logger.InitLogger(conf); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range logger.EffectiveConfig() {
+		if (v.Key == "log_level" || v.Key == "loki.timeout") && v.Source != SourceFile {
+			t.Errorf("%s: expected file source, got %+v", v.Key, v)
+		}
+	}
+}
+
+func TestSinkRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := &FileConfig{Path: dir + "/app.log"}
+	conf := LoggerConfig{LogLevel: "INFO", Sinks: []SinkConfig{{Type: SinkFile, File: file}}}
+	loggerA := GetLogger("registry_a", "")
+	loggerB := GetLogger("registry_b", "")
+	loggerC := GetLogger("registry_c", "")
+	confA, confB, confC := conf, conf, conf
+	confC.SinkInstance = "separate"
+	for logger, c := range map[*CustomLogger]*LoggerConfig{loggerA: &confA, loggerB: &confB, loggerC: &confC} {
+		if err := logger.InitLogger(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	shared := sinkKey(SinkFile, "", *file)
+	separate := sinkKey(SinkFile, "separate", *file)
+	if refs := sinkRefs(); refs[shared] != 2 || refs[separate] != 1 {
+		t.Fatalf("unexpected refs %v", refs)
+	}
+	handle := loggerA.sinks[0].(*sharedSink).entry.handle
+	if loggerB.sinks[0].(*sharedSink).entry.handle != handle {
+		t.Errorf("loggers with the same sink config should share one handle")
+	}
+	if loggerC.sinks[0].(*sharedSink).entry.handle == handle {
+		t.Errorf("loggers with different SinkInstance should not share")
+	}
+
+	// 重复关闭只释放一次引用，最后一个使用者关闭后输出才关闭
+	loggerA.WriterClose()
+	loggerA.WriterClose()
+	if refs := sinkRefs(); refs[shared] != 1 {
+		t.Fatalf("expected 1 ref after close, got %v", refs)
+	}
+	loggerB.Info(&LogRecord{Message: "still open"})
+	loggerB.Flush()
+	loggerB.WriterClose()
+	loggerC.WriterClose()
+	if refs := sinkRefs(); refs[shared] != 0 || refs[separate] != 0 {
+		t.Errorf("expected all sinks released, got %v", refs)
+	}
+	if _, err := handle.Write([]byte("closed")); err != os.ErrClosed {
+		t.Errorf("expected handle closed, got %v", err)
+	}
+	if content, _ := ioutil.ReadFile(file.Path); !strings.Contains(string(content), "still open") {
+		t.Errorf("record not written: %q", content)
+	}
+}
+
+func TestLoggerHierarchy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log_hierarchy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pool := GetLogger("orders.db.pool", "")
+	if pool.Parent() != Logger {
+		t.Fatalf("expected root parent, got %v", pool.Parent())
+	}
+	orders := GetLogger("orders", "")
+	if pool.Parent() != orders || orders.Parent() != Logger {
+		t.Fatalf("pool should move under orders")
+	}
+	db := GetLogger("orders.db", "")
+	if pool.Parent() != db || db.Parent() != orders {
+		t.Fatalf("pool should move under orders.db")
+	}
+
+	// 等级沿层级继承，修改上级立即生效
+	orders.SetLevel(ERROR)
+	if pool.EffectiveLevel() != ERROR || pool.isEnableLog(WARNING) {
+		t.Errorf("level not inherited: %d", pool.EffectiveLevel())
+	}
+	db.SetLevel(DEBUG)
+	if pool.EffectiveLevel() != DEBUG {
+		t.Errorf("nearest ancestor level should win: %d", pool.EffectiveLevel())
+	}
+
+	// 没有配置的 logger 使用最近的已配置上级的输出和默认字段
+	logPath := dir + "/orders.log"
+	err = orders.InitLogger(&LoggerConfig{
+		LogLevel:   "INFO",
+		LoggerName: "orders_svc",
+		Sinks:      []SinkConfig{{Type: SinkFile, File: &FileConfig{Path: logPath}}},
 	})
-	t.Parallel()
-	// 自定义配置
-	// init log,if you need
-	//logger, _ := New("test", "12345")
-	defer func() {
-		if err := recover(); err != nil {
-			logger.Error(&LogRecord{
-				Message: "message-debug",
-				ExcInfo: err.(error).Error()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer orders.WriterClose()
+	pool.Info(&LogRecord{Message: "from pool"})
+	pool.SetPropagate(false)
+	pool.Info(&LogRecord{Message: "not propagated"})
+	pool.Flush()
+
+	content, _ := ioutil.ReadFile(logPath)
+	if !strings.Contains(string(content), `"@global_tag":"orders_svc"`) || !strings.Contains(string(content), "from pool") {
+		t.Errorf("record not written to ancestor output: %q", content)
+	}
+	if strings.Contains(string(content), "not propagated") {
+		t.Errorf("record should not propagate: %q", content)
+	}
+}
+
+func TestUnconfiguredChildSetters(t *testing.T) {
+	parentOut, childOut := make(chanWriter, 4), make(chanWriter, 4)
+	parent := GetLogger("setters", "")
+	parent.SetPropagate(false)
+	parent.SetWriter([]io.Writer{parentOut})
+	parent.SetGlobalTag("parent_svc")
+	child := GetLogger("setters.child", "")
+	leaf := GetLogger("setters.child.leaf", "")
+
+	child.Info(&LogRecord{Message: "inherited"})
+	if record := parentOut.nextRecord(t); record["@global_tag"] != "parent_svc" {
+		t.Errorf("expected parent global tag, got %v", record)
+	}
+
+	// 没有调用 InitLogger 时，Set 方法设置的字段和输出也会生效，并由下级继承
+	child.SetGlobalTag("child_svc")
+	child.SetWriter([]io.Writer{childOut})
+	if child.GetGlobalTag() != "child_svc" {
+		t.Errorf("unexpected GetGlobalTag %q", child.GetGlobalTag())
+	}
+	leaf.Info(&LogRecord{Message: "overridden"})
+	for _, out := range []chanWriter{childOut, parentOut} {
+		if record := out.nextRecord(t); record["@global_tag"] != "child_svc" || record["message"] != "overridden" {
+			t.Errorf("expected child global tag, got %v", record)
+		}
+	}
+}
+
+func TestObserverPropagation(t *testing.T) {
+	observed := NewObserver()
+	parent := GetLogger("observed", "")
+	parent.SetPropagate(false)
+	parent.SetObserver(observed)
+	child := GetLogger("observed.child", "")
+
+	// 下级的记录沿 Propagate 链交给上级的观察者，同一个观察者只记录一次
+	child.Info(&LogRecord{Message: "from child"})
+	child.SetObserver(observed)
+	child.SimpleLog(WARNING, "simple from child")
+	entries := observed.TakeAll()
+	if len(entries) != 2 || entries[0].LoggerName != "observed.child" || entries[0].Message != "from child" ||
+		entries[1].Message != "simple from child" {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	child.SetObserver(nil)
+	child.SetPropagate(false)
+	child.Info(&LogRecord{Message: "not propagated"})
+	if observed.Len() != 0 {
+		t.Errorf("record should not reach parent observer: %+v", observed.All())
+	}
+}
+
+func TestLevelType(t *testing.T) {
+	level := Level(WARNING)
+	if level.String() != "WARNING" || Level(7).String() != "7" {
+		t.Errorf("unexpected String: %s %s", level, Level(7))
+	}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Var(&level, "log-level", "")
+	if err := flags.Parse([]string{"-log-level", "err"}); err != nil || level != ERROR {
+		t.Errorf("flag parse: %v %s", err, level)
+	}
+	if err := level.Set("verbose"); !errors.Is(err, NoMatchLogLevel) {
+		t.Errorf("expected NoMatchLogLevel, got %v", err)
+	}
+
+	var conf struct {
+		Level Level `json:"level"`
+	}
+	if err := json.Unmarshal([]byte(`{"level":"crit"}`), &conf); err != nil || conf.Level != CRITICAL {
+		t.Errorf("json unmarshal: %v %s", err, conf.Level)
+	}
+	if content, _ := json.Marshal(conf); string(content) != `{"level":"CRITICAL"}` {
+		t.Errorf("json marshal: %s", content)
+	}
+	fatal, _ := levelInfo(FATAL)
+	fixed, _ := levelInfo(FIXED)
+	if fatal.Short == fixed.Short {
+		t.Errorf("FATAL and FIXED share short code %q", fixed.Short)
+	}
+
+	// Log、SetLevel 等仍然接收 int，从配置读到的 int 等级不需要转换
+	configured := WARNING
+	logger, logs := NewObservedLogger(configured)
+	logger.Log(int(level), &LogRecord{Message: "from flag"})
+	if entries := logs.All(); len(entries) != 1 || entries[0].Level != ERROR {
+		t.Errorf("unexpected entries %+v", entries)
+	}
+}
+
+func TestRegisterLevel(t *testing.T) {
+	const NOTICE = 25
+	defer currentLevels.Store(currentLevels.Load())
+
+	// 注册时其他协程在写日志，go test -race 下不能有数据竞争
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		observed, _ := NewObservedLogger(DEBUG)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			observed.Log(NOTICE, &LogRecord{Message: "racing"})
+			ParseLevel("notice")
 		}
 	}()
+	err := RegisterLevel(LevelInfo{Level: NOTICE, Name: "notice", Short: "N", Color: 32, Syslog: LOG_NOTICE})
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+	if level, err := ParseLevel("Notice"); err != nil || level != NOTICE {
+		t.Errorf("ParseLevel(Notice) = %d, %v", level, err)
+	}
+	if info, ok := levelInfo(NOTICE); !ok || info.Syslog != LOG_NOTICE || info.Color != 32 || info.Background != 40 {
+		t.Errorf("unexpected level info %+v", info)
+	}
+	if _, ok := LevelToName[NOTICE]; ok {
+		t.Errorf("RegisterLevel should not write the deprecated maps")
+	}
+	// 废弃的等级表是冻结的快照，写入不会影响解析和输出
+	NameToLevel["VERBOSE"] = 15
+	LevelToName[15] = "VERBOSE"
+	defer delete(NameToLevel, "VERBOSE")
+	defer delete(LevelToName, 15)
+	if _, err := ParseLevel("verbose"); err == nil || Level(15).String() != "15" {
+		t.Errorf("writes to the deprecated maps should be ignored")
+	}
 
-	// method 1
-	logger.Warning(&LogRecord{
-		Message: "message-warning",
-		Tag:     "test-warning",
-		TraceId: "555",
-		ExcInfo: errors.New("test warning").Error()})
+	for _, info := range []LevelInfo{
+		{Level: 26, Name: "NOTICE", Short: "O"},
+		{Level: NOTICE, Name: "NOTICE2", Short: "O"},
+		{Level: 27, Name: "AUDIT", Short: "F"},
+		{Level: 28, Name: "WARN", Short: "A"},
+		{Level: 0, Name: "ZERO", Short: "Z"},
+		{Level: 29, Name: "", Short: "Z"},
+	} {
+		if err := RegisterLevel(info); err == nil {
+			t.Errorf("RegisterLevel(%+v) should fail", info)
+		}
+	}
 
-	logger.Critical(&LogRecord{
-		Message: "message-critical",
-		Tag:     "test-critical",
-		TraceId: "666",
-		ExcInfo: errors.New("test critical").Error()})
-	// method 2
-	logger.Log(ERROR, &LogRecord{
-		Message: "message-error-3",
-		Tag:     "test-error-3",
-		TraceId: "666"})
-	// method 3
-	logger.Error(&LogRecord{
-		Message: "message-error-4",
-		Tag:     "test-error-4",
-		TraceId: "777"})
+	logger, logs := NewObservedLogger(INFO)
+	buf := &bytes.Buffer{}
+	w := GetLockWriter(buf, &sync.Mutex{})
+	logger.SetWriter([]io.Writer{w})
+	logger.SetStdoutFormat("custom")
+	logger.SetCustomWriter(w)
+	logger.Log(NOTICE, &LogRecord{Message: "config reloaded"})
+	logger.Flush()
+	if entries := logs.All(); len(entries) != 1 || entries[0].LevelName != "NOTICE" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	if out := buf.String(); !strings.Contains(out, `"level_name":"NOTICE"`) || !strings.Contains(out, "[N]") {
+		t.Errorf("unexpected output %q", out)
+	}
+}
 
-	// test panic
-	panic(errors.New("test error"))
+// go test -race -run TestConcurrentSetters
+func TestConcurrentSetters(t *testing.T) {
+	logger := GetLogger("concurrent.setters", "")
+	if err := logger.InitLogger(&LoggerConfig{LogLevel: "INFO", LoggerName: "concurrent"}); err != nil {
+		t.Fatal(err)
+	}
+	defer logger.WriterClose()
+	child := GetLogger("concurrent.setters.child", "")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				logger.Info(&LogRecord{Message: "concurrent"})
+				child.Error(&LogRecord{Message: "concurrent child"})
+				// 导出字段创建后不再修改，与 Set 方法并发读取也没有数据竞争
+				_, _ = logger.Level, logger.GlobalTag
+				_, _ = logger.GetLevel(), logger.GetGlobalTag()
+				_, _ = Logger, Default()
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		logger.SetLevel(DEBUG + i%2*10)
+		logger.SetWriter([]io.Writer{ioutil.Discard})
+		logger.SetDefaultTag("tag-" + strconv.Itoa(i))
+		logger.SetGlobalTag("global-" + strconv.Itoa(i))
+		logger.SetFixedFlag(i%2 == 0)
+		logger.SetStackFormat(StackFormatBoth)
+		logger.SetStackDepth(i)
+		logger.SetPropagate(i%2 == 0)
+		SetLogCallDepth(int(GetLogCallDepth()))
+		SetDefault(SetDefault(logger))
+		child.SetStdoutFormat("custom")
+		child.SetCustomWriter(ioutil.Discard)
+		if err := logger.SetMetadataFields([]string{"pid"}); err != nil {
+			t.Fatal(err)
+		}
+		if i%10 == 0 {
+			if err := logger.InitLogger(&LoggerConfig{LogLevel: "DEBUG", LoggerName: "concurrent"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		logger.Flush()
+	}
+	close(stop)
+	wg.Wait()
+	logger.Flush()
+	if logger.GetLevel() != INFO || logger.GetGlobalTag() != "global-49" {
+		t.Errorf("unexpected state level=%d global_tag=%q", logger.GetLevel(), logger.GetGlobalTag())
+	}
+	if logger.GetTag() != "tag-49" || logger.GetFixedFlag() || child.GetStdoutFormat() != "custom" || child.GetSimpleLogStatus() {
+		t.Errorf("unexpected state tag=%q fixed=%v format=%q", logger.GetTag(), logger.GetFixedFlag(), child.GetStdoutFormat())
+	}
+
+	// 导出字段是创建时的快照：Set 方法不更新它们，对它们赋值也不影响日志
+	snapshot := GetLogger("concurrent.setters.snapshot", "")
+	created := snapshot.Level
+	snapshot.SetLevel(FATAL)
+	snapshot.Level = DEBUG
+	if snapshot.GetLevel() != FATAL || snapshot.isEnableLog(ERROR) || created == FATAL {
+		t.Errorf("exported fields should be snapshots, level=%d created=%d", snapshot.GetLevel(), created)
+	}
+}
+
+func TestFatalPolicy(t *testing.T) {
+	var codes []int
+	defer SetExitFunc(SetExitFunc(func(code int) { codes = append(codes, code) }))
+	defer SetFatalPolicy(SetFatalPolicy(FatalPolicy{ExitCode: 3, FlushTimeout: time.Second}))
+
+	dir, err := ioutil.TempDir("", "log_fatal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logger := GetLogger("fatal.policy", "")
+	logPath := dir + "/fatal.log"
+	err = logger.InitLogger(&LoggerConfig{
+		LogLevel: "INFO",
+		Sinks:    []SinkConfig{{Type: SinkFile, File: &FileConfig{Path: logPath}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logger.WriterClose()
+
+	var hooks []string
+	enabled := true
+	RegisterExitHook(func() {
+		if enabled {
+			hooks = append(hooks, "first")
+			// 钩子中再写 FATAL 不会重复处理
+			logger.Fatal(&LogRecord{Message: "fatal in hook"})
+		}
+	})
+	RegisterExitHook(func() {
+		if enabled {
+			panic("hook panic")
+		}
+	})
+	RegisterExitHook(func() {
+		if enabled {
+			hooks = append(hooks, "last")
+		}
+	})
+	defer func() { enabled = false }()
+
+	logger.Fatal(&LogRecord{Message: "fatal record"})
+	if len(codes) != 1 || codes[0] != 3 {
+		t.Fatalf("expected one exit with code 3, got %v", codes)
+	}
+	if strings.Join(hooks, ",") != "first,last" {
+		t.Errorf("unexpected hooks %v", hooks)
+	}
+	content, _ := ioutil.ReadFile(logPath)
+	if !strings.Contains(string(content), "fatal record") {
+		t.Errorf("fatal record not flushed before exit: %q", content)
+	}
+
+	// 未开启等级时同样退出，NoExit 时只执行钩子
+	hooks = nil
+	SetFatalPolicy(FatalPolicy{NoExit: true})
+	observed, _ := NewObservedLogger(FIXED)
+	observed.Log(FATAL, &LogRecord{Message: "disabled"})
+	Fatalf("package %s", "fatal")
+	if len(codes) != 1 || len(hooks) != 4 {
+		t.Errorf("NoExit should only run hooks, codes %v hooks %v", codes, hooks)
+	}
+}
+
+func TestConcurrentFatal(t *testing.T) {
+	logger, logs := NewObservedLogger(DEBUG)
+	var mu sync.Mutex
+	exits := 0
+	defer SetExitFunc(SetExitFunc(func(code int) {
+		// 等两个 FATAL 都写出，第二个已经进入 FATAL 处理
+		for i := 0; i < 100 && logs.Len() < 2; i++ {
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		exits++
+		mu.Unlock()
+	}))
+	defer SetFatalPolicy(SetFatalPolicy(FatalPolicy{FlushTimeout: time.Second}))
+
+	returned := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			logger.Fatal(&LogRecord{Message: "concurrent fatal"})
+			mu.Lock()
+			returned <- exits
+			mu.Unlock()
+		}()
+	}
+	// 只退出一次，另一个 FATAL 等到退出之后才返回(使用 os.Exit 时不会返回)
+	for i := 0; i < 2; i++ {
+		if n := <-returned; n != 1 {
+			t.Errorf("fatal returned with %d exits, want 1", n)
+		}
+	}
+}
+
+func TestFatalKeepsSharedSink(t *testing.T) {
+	server := newRecordServer()
+	defer server.Close()
+	var atExit []string
+	var codes []int
+	defer SetExitFunc(SetExitFunc(func(code int) {
+		atExit = server.messages()
+		codes = append(codes, code)
+	}))
+	defer SetFatalPolicy(SetFatalPolicy(FatalPolicy{NoExit: true, FlushTimeout: time.Second}))
+
+	bufferPath, err := ioutil.TempDir("", "fatal_shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(bufferPath)
+
+	// linger 足够长，记录只会在 flush 或关闭时发出
+	conf := func() *LoggerConfig {
+		return &LoggerConfig{
+			LogLevel: "INFO",
+			Sinks:    []SinkConfig{{Type: SinkHttp, Http: &HttpConfig{Url: server.URL, Linger: 30, BufferPath: bufferPath}}},
+		}
+	}
+	a := GetLogger("fatal.shared_a", "")
+	b := GetLogger("fatal.shared_b", "")
+	if err = a.InitLogger(conf()); err != nil {
+		t.Fatal(err)
+	}
+	if err = b.InitLogger(conf()); err != nil {
+		t.Fatal(err)
+	}
+
+	// NoExit 时 FATAL 只等待写出和执行钩子，共享的输出仍然可用
+	a.Fatal(&LogRecord{Message: "fatal a"})
+	for i := 0; i < 5; i++ {
+		b.Info(&LogRecord{Message: "after fatal " + strconv.Itoa(i)})
+	}
+	a.Info(&LogRecord{Message: "a after fatal"})
+	b.Flush()
+
+	// 退出时共享的批量输出也在退出前发出，其他 logger 仍然可以使用
+	SetFatalPolicy(FatalPolicy{FlushTimeout: 2 * time.Second})
+	a.Fatal(&LogRecord{Message: "fatal exit"})
+	b.Info(&LogRecord{Message: "b after exit"})
+	b.Flush()
+	b.WriterClose()
+
+	messages := server.messages()
+	if len(codes) != 1 || len(atExit) != 8 || !strings.Contains(strings.Join(atExit, ","), "fatal exit") {
+		t.Errorf("records not delivered before exit, codes %v messages %v", codes, atExit)
+	}
+	got := strings.Join(messages, ",")
+	if len(messages) != 9 || !strings.Contains(got, "after fatal 4") || !strings.Contains(got, "b after exit") {
+		t.Errorf("records lost after fatal, messages %v", messages)
+	}
+}
+
+func TestLoggerRegistry(t *testing.T) {
+	defer ResetForTests()
+	dir, err := ioutil.TempDir("", "log_registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	parent := GetLogger("registry", "")
+	child := GetLogger("registry.db.pool", "")
+	db := GetLogger("registry.db", "")
+	names := ListLoggers()
+	for _, name := range []string{RootLoggerName, "registry", "registry.db", "registry.db.pool"} {
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+		if !found {
+			t.Errorf("%s not listed in %v", name, names)
+		}
+	}
+
+	sink := SinkConfig{Type: SinkFile, File: &FileConfig{Path: dir + "/db.log"}}
+	if err := db.InitLogger(&LoggerConfig{LogLevel: "INFO", Sinks: []SinkConfig{sink}}); err != nil {
+		t.Fatal(err)
+	}
+	key := sinkKey(SinkFile, "", sink.File)
+	if sinkRefs()[key] != 1 {
+		t.Fatalf("expected file sink to be registered, got %v", sinkRefs())
+	}
+	if !RemoveLogger("registry.db") || RemoveLogger("registry.db") || RemoveLogger(RootLoggerName) {
+		t.Errorf("unexpected RemoveLogger result")
+	}
+	if _, ok := sinkRefs()[key]; ok {
+		t.Errorf("sink not closed after RemoveLogger")
+	}
+	if child.Parent() != parent {
+		t.Errorf("child should move under registry, got %v", child.Parent().Name)
+	}
+
+	// 包级函数使用 SetDefault 设置的 logger
+	logger, observed := NewObservedLogger(DEBUG)
+	old := SetDefault(logger)
+	Info("to default")
+	if Default() != logger || Logger == logger || observed.Len() != 1 {
+		t.Errorf("package functions should use the new default")
+	}
+	if SetDefault(old) != logger || Default() != old {
+		t.Errorf("SetDefault should return the previous logger")
+	}
+
+	GetLogger("registry", "").SetLevel(ERROR)
+	GlobalConf.LogLevel = "DEBUG"
+	ResetForTests()
+	if names := ListLoggers(); len(names) != 1 || names[0] != RootLoggerName {
+		t.Errorf("expected only root logger after reset, got %v", names)
+	}
+	if GlobalConf.LogLevel != "INFO" || Logger.GetLevel() != INFO || GetLogger("registry", "").EffectiveLevel() != INFO {
+		t.Errorf("reset should restore defaults")
+	}
+}
+
+type fieldError struct {
+	msg   string
+	cause error
+}
+
+func (e *fieldError) Error() string { return e.msg }
+func (e *fieldError) Unwrap() error { return e.cause }
+
+func TestTypedNilError(t *testing.T) {
+	logger, observed := NewObservedLogger(DEBUG)
+	out := make(chanWriter, 1)
+	logger.SetWriter([]io.Writer{out})
+	defer SetDefault(SetDefault(logger))
+
+	var nilErr *fieldError
+	Error(nilErr)
+	record := out.nextRecord(t)
+	if record["message"] != "<nil>" || record["exc_type"] != "*navi_go_log.fieldError" {
+		t.Errorf("unexpected record for typed nil error: %v", record)
+	}
+	logger.Warning(&LogRecord{Message: "wrapped", Err: fmt.Errorf("wrap: %w", nilErr)})
+	record = out.nextRecord(t)
+	chain, _ := record["exc_chain"].([]interface{})
+	if len(chain) != 2 || chain[1].(map[string]interface{})["message"] != "<nil>" {
+		t.Errorf("unexpected exc_chain: %v", record["exc_chain"])
+	}
+	if entries := observed.TakeAll(); len(entries) != 2 || entries[0].ExcInfo != "<nil>" {
+		t.Errorf("unexpected entries %+v", entries)
+	}
 }
 
 // go test -bench="."
@@ -303,6 +2319,36 @@ func BenchmarkLogParallel2(b *testing.B) {
 
 }
 
+// 调用信息解析：缓存与不缓存对比
+func BenchmarkCallerResolve(b *testing.B) {
+	var pcs [1]uintptr
+	runtime.Callers(1, pcs[:])
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resolveCallerNoCache(pcs[0])
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			resolveCaller(pcs[0])
+		}
+	})
+}
+
+func BenchmarkSetFuncInfo(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			setFuncInfo(1)
+		}
+	})
+}
+
+func BenchmarkCallersWithFirstCallInfo(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		CallersWithFirstCallInfo(0)
+	}
+}
+
 func TestPrint(t *testing.T) {
 
 }
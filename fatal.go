@@ -0,0 +1,191 @@
+package navi_go_log
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 等待 FATAL 日志写出的默认最长时间，与原来 Fatal 的等待时间一致
+const defaultFatalFlushTimeout = 4 * time.Second
+
+// FatalPolicy FATAL 日志写出后的处理：等待日志写出、执行退出钩子、退出进程。
+// nLog.Fatal、nLog.Fatalf、logger.Fatal 和 logger.Log(FATAL, ...) 都按这个策略处理
+type FatalPolicy struct {
+	ExitCode     int           // 退出码，为 0 时使用 1
+	FlushTimeout time.Duration // 等待日志写出和退出钩子的最长时间，为 0 时使用 4 秒，超时后直接退出
+	NoExit       bool          // 只写出日志和执行退出钩子，不退出进程
+}
+
+var (
+	fatalPolicy atomic.Value // FatalPolicy
+	exitFunc    atomic.Value // func(int)
+	// fatalRunning 正在处理的 FATAL，done 在处理结束(退出函数返回或 NoExit)时关闭；
+	// hookGoroutine 为执行退出钩子的协程号，钩子中再写 FATAL 时不重复处理
+	fatalRunning struct {
+		sync.Mutex
+		done          chan struct{}
+		hookGoroutine int64
+	}
+	exitHooks struct {
+		sync.Mutex
+		hooks []func()
+	}
+)
+
+func init() {
+	fatalPolicy.Store(FatalPolicy{})
+	exitFunc.Store(os.Exit)
+}
+
+// SetFatalPolicy 设置 FATAL 的处理策略，返回原来的策略
+func SetFatalPolicy(policy FatalPolicy) FatalPolicy {
+	old := fatalPolicy.Load().(FatalPolicy)
+	fatalPolicy.Store(policy)
+	return old
+}
+
+// SetExitFunc 替换退出进程的函数，传入 nil 时恢复 os.Exit，返回原来的函数。
+// 测试中可以用它检查 FATAL 的处理而不退出测试进程：
+//
+//	defer nLog.SetExitFunc(nLog.SetExitFunc(func(code int) { exitCode = code }))
+func SetExitFunc(exit func(code int)) func(code int) {
+	if exit == nil {
+		exit = os.Exit
+	}
+	old := exitFunc.Load().(func(int))
+	exitFunc.Store(exit)
+	return old
+}
+
+// RegisterExitHook 注册 FATAL 退出前执行的函数，如关闭数据库连接，按注册顺序执行。
+// 钩子中的 panic 会被忽略，不影响后面的钩子和退出
+func RegisterExitHook(hook func()) {
+	exitHooks.Lock()
+	exitHooks.hooks = append(exitHooks.hooks, hook)
+	exitHooks.Unlock()
+}
+
+// fatal 写出 FATAL 日志后按 FatalPolicy 处理：等待异步写入、执行退出钩子、
+// 发送记录写入的批量输出的缓存队列并释放输出，全部完成或超时后退出。NoExit 时不处理输出，logger 可以继续使用。
+// 其他协程同时写 FATAL 时等待正在处理的 FATAL 结束，退出进程时不会返回
+func (logger *CustomLogger) fatal() {
+	fatalRunning.Lock()
+	if running := fatalRunning.done; running != nil {
+		hookGoroutine := fatalRunning.hookGoroutine
+		fatalRunning.Unlock()
+		if goroutineId() != hookGoroutine {
+			<-running
+		}
+		return
+	}
+	finished := make(chan struct{})
+	fatalRunning.done = finished
+	fatalRunning.Unlock()
+	defer func() {
+		fatalRunning.Lock()
+		fatalRunning.done, fatalRunning.hookGoroutine = nil, 0
+		fatalRunning.Unlock()
+		close(finished)
+	}()
+
+	policy := fatalPolicy.Load().(FatalPolicy)
+	timeout := policy.flushTimeout()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logger.Flush()
+		fatalRunning.Lock()
+		fatalRunning.hookGoroutine = goroutineId()
+		fatalRunning.Unlock()
+		runExitHooks()
+		if !policy.NoExit {
+			logger.flushOutputs(timeout)
+			logger.releaseOutputs()
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		fmt.Fprintln(os.Stderr, "fatal flush timeout", timeout)
+	}
+	if policy.NoExit {
+		return
+	}
+	code := policy.ExitCode
+	if code == 0 {
+		code = 1
+	}
+	exitFunc.Load().(func(int))(code)
+}
+
+func (policy FatalPolicy) flushTimeout() time.Duration {
+	if policy.FlushTimeout <= 0 {
+		return defaultFatalFlushTimeout
+	}
+	return policy.FlushTimeout
+}
+
+func runExitHooks() {
+	exitHooks.Lock()
+	hooks := make([]func(), len(exitHooks.hooks))
+	copy(hooks, exitHooks.hooks)
+	exitHooks.Unlock()
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					fmt.Fprintln(os.Stderr, "exit hook panic", r)
+				}
+			}()
+			hook()
+		}()
+	}
+}
+
+// outputOwners 返回记录会写入其输出的 logger，与 loggerState.outputs 相同：自己和 Propagate 链上有输出的上级
+func (logger *CustomLogger) outputOwners() []*CustomLogger {
+	var owners []*CustomLogger
+	for l := logger; l != nil; l = l.Parent() {
+		st := l.load()
+		if st.hasOutputs() {
+			owners = append(owners, l)
+		}
+		if !st.propagate {
+			break
+		}
+	}
+	return owners
+}
+
+// flushOutputs 等待记录写入的各个 logger 的异步写入结束，再同步发送它们的批量输出的缓存队列，
+// 与其他 logger 共享的输出也会发送，总共最多等待 timeout。用于进程退出前保证记录发出
+func (logger *CustomLogger) flushOutputs(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for _, l := range logger.outputOwners() {
+		l.Flush()
+		l.mu.Lock()
+		sinks := l.sinks
+		l.mu.Unlock()
+		for _, sink := range sinks {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return
+			}
+			if f, ok := sink.(batchFlusher); ok {
+				f.flushBatch(remaining)
+			}
+		}
+	}
+}
+
+// releaseOutputs 释放记录写入的各个 logger 对输出的引用。
+// 没有其他 logger 引用的输出会被关闭；其他 logger 仍在使用的共享输出不会关闭
+func (logger *CustomLogger) releaseOutputs() {
+	for _, l := range logger.outputOwners() {
+		l.Flush()
+		l.WriterClose()
+	}
+}
@@ -0,0 +1,107 @@
+package navi_go_log
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FileConfig 文件输出配置，按大小切割
+type FileConfig struct {
+	Path       string `json:"path,omitempty" yaml:"path,omitempty"`               // 日志文件路径，目录不存在时自动创建
+	MaxSize    int    `json:"max_size,omitempty" yaml:"max_size,omitempty"`       // 单个文件最大大小(MB)，超过后切割，0 表示不切割
+	MaxBackups int    `json:"max_backups,omitempty" yaml:"max_backups,omitempty"` // 保留的历史文件数，默认 5
+}
+
+// FileHandle 追加写入本地文件的输出，切割后的文件名为 Path.1、Path.2 ...，数字越大越旧
+type FileHandle struct {
+	conf FileConfig
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenFile 创建文件输出
+func OpenFile(conf FileConfig) (*FileHandle, error) {
+	if conf.Path == "" {
+		return nil, errors.New("file path is empty")
+	}
+	if conf.MaxBackups <= 0 {
+		conf.MaxBackups = 5
+	}
+	if err := os.MkdirAll(filepath.Dir(conf.Path), os.ModePerm); err != nil {
+		return nil, err
+	}
+	F := &FileHandle{conf: conf}
+	if err := F.open(); err != nil {
+		return nil, err
+	}
+	return F, nil
+}
+
+func (F *FileHandle) open() error {
+	file, err := os.OpenFile(F.conf.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	F.file = file
+	F.size = info.Size()
+	return nil
+}
+
+func (F *FileHandle) Write(b []byte) (n int, err error) {
+	F.mu.Lock()
+	defer F.mu.Unlock()
+	if F.file == nil {
+		return 0, os.ErrClosed
+	}
+	if F.conf.MaxSize > 0 && F.size > 0 && F.size+int64(len(b)) > int64(F.conf.MaxSize)<<20 {
+		if err := F.rotate(); err != nil {
+			fmt.Fprintln(os.Stderr, "file rotate fail", err)
+		}
+	}
+	n, err = F.file.Write(b)
+	F.size += int64(n)
+	return n, err
+}
+
+func (F *FileHandle) WriteString(msg string) (n int, err error) {
+	if !strings.HasSuffix(msg, "\n") {
+		msg = msg + "\n"
+	}
+	return F.Write([]byte(msg))
+}
+
+func (F *FileHandle) Close() error {
+	F.mu.Lock()
+	defer F.mu.Unlock()
+	if F.file == nil {
+		return nil
+	}
+	err := F.file.Close()
+	F.file = nil
+	return err
+}
+
+// rotate 依次把 Path.n 重命名为 Path.n+1，超出 MaxBackups 的删除，再重新打开 Path
+func (F *FileHandle) rotate() error {
+	F.file.Close()
+	F.file = nil
+	path := F.conf.Path
+	os.Remove(fmt.Sprintf("%s.%d", path, F.conf.MaxBackups))
+	for i := F.conf.MaxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	if err := os.Rename(path, path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return F.open()
+}
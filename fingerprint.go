@@ -0,0 +1,48 @@
+package navi_go_log
+
+import (
+	"hash/fnv"
+	"regexp"
+	"strconv"
+)
+
+// 计算错误指纹使用的调用栈层数
+const fingerprintDepth = 5
+
+// 错误信息中会变化的部分，计算指纹前替换成占位符
+var fingerprintReplacers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`0[xX][0-9a-fA-F]+`), "<hex>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]*[0-9][0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*\b|\b[0-9a-fA-F]*[a-fA-F][0-9a-fA-F]*[0-9][0-9a-fA-F]*\b`), "<id>"},
+	{regexp.MustCompile(`[0-9]+`), "<n>"},
+}
+
+// normalizeErrorMessage 去掉错误信息中的数字、十六进制 id 和 uuid，
+// 如 "user 123 not found" 和 "user 456 not found" 得到相同的结果
+func normalizeErrorMessage(msg string) string {
+	for _, r := range fingerprintReplacers {
+		msg = r.re.ReplaceAllString(msg, r.repl)
+	}
+	return msg
+}
+
+// errorFingerprint 由前几层调用栈的函数名、文件名(不含行号，避免代码改动后变化)和规范化的错误信息计算指纹
+func errorFingerprint(stack Stack, errType, msg string) string {
+	h := fnv.New64a()
+	if len(stack) > fingerprintDepth {
+		stack = stack[:fingerprintDepth]
+	}
+	for _, f := range stack {
+		h.Write([]byte(f.Name))
+		h.Write([]byte{'@'})
+		h.Write([]byte(f.File))
+		h.Write([]byte{'\n'})
+	}
+	h.Write([]byte(errType))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(normalizeErrorMessage(msg)))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
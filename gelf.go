@@ -0,0 +1,282 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gelfVersion          = "1.1"
+	gelfDefaultChunkSize = 1420
+	gelfMaxChunks        = 128
+	gelfChunkHeaderSize  = 12
+)
+
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// GelfConfig GELF(Graylog)输出配置
+type GelfConfig struct {
+	Network   string `json:"network,omitempty" yaml:"network,omitempty"`       // udp 或 tcp，默认 udp
+	Addr      string `json:"addr,omitempty" yaml:"addr,omitempty"`             // Graylog GELF input 地址，如 192.168.26.100:12201
+	Compress  string `json:"compress,omitempty" yaml:"compress,omitempty"`     // UDP 压缩方式：gzip、zlib 或 none，默认 gzip。TCP 不压缩
+	ChunkSize int    `json:"chunk_size,omitempty" yaml:"chunk_size,omitempty"` // UDP 分块大小，默认 1420
+	Host      string `json:"host,omitempty" yaml:"host,omitempty"`             // GELF host 字段，默认为主机名
+}
+
+// GelfHandle 把 Log 生成的 json 记录转换成 GELF 1.1 格式发送到 Graylog
+type GelfHandle struct {
+	conf   GelfConfig
+	host   string
+	mu     sync.Mutex // 串行写入，保证分块和帧不交错
+	conn   net.Conn
+	closed bool // Close 后不再重连，写入返回错误
+}
+
+// DialGelf 创建 GELF 输出
+func DialGelf(conf GelfConfig) (*GelfHandle, error) {
+	if conf.Network == "" {
+		conf.Network = "udp"
+	}
+	if conf.Network != "udp" && conf.Network != "tcp" {
+		return nil, errors.New("gelf only support udp and tcp")
+	}
+	if conf.Addr == "" {
+		return nil, errors.New("gelf addr is empty")
+	}
+	if conf.Compress == "" {
+		conf.Compress = "gzip"
+	}
+	if conf.Compress != "gzip" && conf.Compress != "zlib" && conf.Compress != "none" {
+		return nil, fmt.Errorf("gelf unknown compress type %q", conf.Compress)
+	}
+	if conf.ChunkSize <= gelfChunkHeaderSize {
+		conf.ChunkSize = gelfDefaultChunkSize
+	}
+	g := &GelfHandle{conf: conf, host: conf.Host}
+	if g.host == "" {
+		g.host, _ = os.Hostname()
+	}
+	conn, err := net.DialTimeout(conf.Network, conf.Addr, 3*time.Second)
+	if err != nil {
+		// tcp 连接失败时在写入时重连
+		if conf.Network == "udp" {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr, "gelf dial fail", err)
+	}
+	g.conn = conn
+	return g, nil
+}
+
+func (g *GelfHandle) Write(b []byte) (n int, err error) {
+	msg, err := g.format(b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gelf format fail", err)
+		return 0, err
+	}
+	if g.conf.Network == "tcp" {
+		err = g.sendTCP(msg)
+	} else {
+		err = g.sendUDP(msg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gelf send fail", err)
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (g *GelfHandle) WriteString(s string) (n int, err error) {
+	return g.Write([]byte(s))
+}
+
+func (g *GelfHandle) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	if g.conn == nil {
+		return nil
+	}
+	err := g.conn.Close()
+	g.conn = nil
+	return err
+}
+
+// format 把一条 json 日志转换成 GELF 消息
+func (g *GelfHandle) format(b []byte) ([]byte, error) {
+	record := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&record); err != nil {
+		return nil, err
+	}
+
+	msg := map[string]interface{}{
+		"version": gelfVersion,
+		"host":    g.host,
+	}
+	levelName, _ := record["level_name"].(string)
+	msg["level"] = gelfLevel(levelName)
+	msg["timestamp"] = gelfTimestamp(record["log_time"])
+
+	shortMessage, _ := record["message"].(string)
+	excInfo, _ := record["exc_info"].(string)
+	stackInfo, _ := record["stack_info"].(string)
+	if shortMessage == "" {
+		shortMessage = excInfo
+	}
+	if shortMessage == "" {
+		shortMessage = "-"
+	}
+	msg["short_message"] = shortMessage
+	if excInfo != "" || stackInfo != "" {
+		msg["full_message"] = strings.TrimSpace(excInfo + "\n" + stackInfo)
+	}
+
+	for k, v := range record {
+		switch k {
+		case "message", "log_time", "exc_info", "stack_info":
+			continue
+		}
+		msg[gelfFieldName(k)] = gelfFieldValue(v)
+	}
+	return json.Marshal(msg)
+}
+
+func (g *GelfHandle) sendTCP(msg []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed {
+		return errors.New("gelf handle is closed")
+	}
+	frame := append(msg, 0)
+	var err error
+	// 失败时重连一次
+	for i := 0; i < 2; i++ {
+		if g.conn == nil {
+			g.conn, err = net.DialTimeout("tcp", g.conf.Addr, 3*time.Second)
+			if err != nil {
+				return err
+			}
+		}
+		g.conn.SetWriteDeadline(time.Now().Add(3 * time.Second))
+		if _, err = g.conn.Write(frame); err == nil {
+			return nil
+		}
+		g.conn.Close()
+		g.conn = nil
+	}
+	return err
+}
+
+func (g *GelfHandle) sendUDP(msg []byte) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closed || g.conn == nil {
+		return errors.New("gelf handle is closed")
+	}
+	payload, err := g.compress(msg)
+	if err != nil {
+		return err
+	}
+	if len(payload) <= g.conf.ChunkSize {
+		_, err = g.conn.Write(payload)
+		return err
+	}
+
+	dataSize := g.conf.ChunkSize - gelfChunkHeaderSize
+	count := (len(payload) + dataSize - 1) / dataSize
+	if count > gelfMaxChunks {
+		return fmt.Errorf("gelf message too large: %d bytes need %d chunks", len(payload), count)
+	}
+	id := make([]byte, 8)
+	if _, err = rand.Read(id); err != nil {
+		return err
+	}
+	chunk := make([]byte, 0, g.conf.ChunkSize)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * dataSize
+		if end > len(payload) {
+			end = len(payload)
+		}
+		chunk = chunk[:0]
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = append(chunk, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, payload[i*dataSize:end]...)
+		if _, err = g.conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GelfHandle) compress(msg []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch g.conf.Compress {
+	case "gzip":
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(msg); err == nil {
+			err = w.Close()
+		}
+	case "zlib":
+		w := zlib.NewWriter(&buf)
+		if _, err = w.Write(msg); err == nil {
+			err = w.Close()
+		}
+	default:
+		return msg, nil
+	}
+	return buf.Bytes(), err
+}
+
+// gelfLevel GELF 使用 syslog 的 severity 作为 level
+func gelfLevel(levelName string) Priority {
+	return levelPriority(levelName)
+}
+
+// gelfTimestamp 把 log_time 转成 GELF 需要的秒级浮点时间戳
+func gelfTimestamp(v interface{}) float64 {
+	now := parseLogTime(v)
+	return float64(now.UnixNano()/int64(time.Millisecond)) / 1000
+}
+
+// gelfFieldName 附加字段需要以 _ 开头，且只能包含字母、数字、下划线、点和横线。_id 为保留字段
+func gelfFieldName(k string) string {
+	name := []byte(strings.TrimLeft(k, "@_"))
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '.' || c == '-') {
+			name[i] = '_'
+		}
+	}
+	if string(name) == "id" {
+		return "_extra_id"
+	}
+	return "_" + string(name)
+}
+
+// gelfFieldValue 附加字段的值只能是字符串或数字，其他类型转成 json 字符串
+func gelfFieldValue(v interface{}) interface{} {
+	switch v.(type) {
+	case string, json.Number:
+		return v
+	case bool:
+		return fmt.Sprintf("%v", v)
+	case nil:
+		return ""
+	default:
+		tmp, _ := json.Marshal(v)
+		return string(tmp)
+	}
+}
@@ -0,0 +1,161 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"strings"
+)
+
+// logger 按名称中的点组成层级，如 orders.db.pool 的上级依次为 orders.db、orders 和 root_logger。
+// 没有调用过 InitLogger 的 logger 使用最近的已配置上级的默认字段(GlobalTag、元数据、栈信息配置)，
+// 用 Set 方法单独设置过的字段和输出除外；
+// 没有设置过等级的 logger 使用最近的设置了等级的上级的等级，修改上级会立即影响所有下级。
+// 记录先写入自己的输出，Propagate 为 true 时再交给上级的输出。
+
+// parentName 返回 name 的直接上级名称，顶层名称的上级为 RootLoggerName
+func parentName(name string) string {
+	if i := strings.LastIndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return RootLoggerName
+}
+
+// linkParent 设置新 logger 的上级，并把原来挂在更上层的下级移到新 logger 下，调用时需持有 lock
+func linkParent(logger *CustomLogger) {
+	if logger.Name == RootLoggerName {
+		return
+	}
+	for name := parentName(logger.Name); ; name = parentName(name) {
+		if parent, ok := loggerManager[name]; ok {
+			logger.update(func(st *loggerState) { st.parent = parent })
+			break
+		}
+		if name == RootLoggerName {
+			break
+		}
+	}
+	prefix := logger.Name + "."
+	for _, child := range loggerManager {
+		parent := child.Parent()
+		if !strings.HasPrefix(child.Name, prefix) || parent == nil {
+			continue
+		}
+		// child 原来的上级是新 logger 的上级时，新 logger 离它更近
+		if parent.Name == RootLoggerName || strings.HasPrefix(logger.Name, parent.Name+".") {
+			child.update(func(st *loggerState) { st.parent = logger })
+		}
+	}
+}
+
+// Parent 返回上级 logger，root_logger 和独立创建的 logger 返回 nil
+func (logger *CustomLogger) Parent() *CustomLogger {
+	return logger.load().parent
+}
+
+// SetPropagate 设置记录是否继续交给上级的输出。
+// GetLogger 创建的 logger 默认为 true，调用 InitLogger 后使用 LoggerConfig.Propagate
+func (logger *CustomLogger) SetPropagate(propagate bool) {
+	logger.update(func(st *loggerState) { st.propagate = propagate })
+}
+
+// EffectiveLevel 返回生效的日志等级，没有设置过等级时使用最近的设置了等级的上级的等级
+func (logger *CustomLogger) EffectiveLevel() int {
+	return logger.load().effectiveLevel()
+}
+
+// inheritLevel 取消自己的等级，改为使用上级的等级
+func (logger *CustomLogger) inheritLevel() {
+	logger.update(func(st *loggerState) {
+		if st.parent != nil {
+			st.levelSet = false
+		}
+	})
+}
+
+func (st *loggerState) effectiveLevel() int {
+	for s := st; s != nil; s = s.parentState() {
+		if s.levelSet {
+			return s.level
+		}
+	}
+	return st.level
+}
+
+func (st *loggerState) parentState() *loggerState {
+	if st.parent == nil {
+		return nil
+	}
+	return st.parent.load()
+}
+
+// fieldSet 没有调用 InitLogger 的 logger 通过 Set 方法单独设置的字段，这些字段不再使用上级的值
+type fieldSet uint
+
+const (
+	fieldOut         fieldSet = 1 << iota // SetWriter、SetCustomWriter 设置的输出，记录写入自己的输出后再交给上级
+	fieldGlobalTag                        // SetGlobalTag
+	fieldSimpleLog                        // SetSimpleLogStatus
+	fieldStackFormat                      // SetStackFormat
+	fieldStackPolicy                      // SetStackPolicy、SetStackDepth
+	fieldMetadata                         // SetMetadataFields
+)
+
+// source 返回提供默认字段的配置：自己或最近的已配置上级
+func (st *loggerState) source() *loggerState {
+	return st.sourceOf(0)
+}
+
+// sourceOf 返回提供 field 的配置：自己或最近的已配置或单独设置过该字段的上级
+func (st *loggerState) sourceOf(field fieldSet) *loggerState {
+	for s := st; s != nil; s = s.parentState() {
+		if s.configured || s.overrides&field != 0 {
+			return s
+		}
+	}
+	return st
+}
+
+// hasOutputs 是否有自己的输出：调用过 InitLogger 或单独设置过输出
+func (st *loggerState) hasOutputs() bool {
+	return st.configured || st.overrides&fieldOut != 0
+}
+
+// outputs 返回记录需要写入的配置：自己和 Propagate 链上有输出的上级
+func (st *loggerState) outputs() []*loggerState {
+	var targets []*loggerState
+	for s := st; s != nil; s = s.parentState() {
+		if s.hasOutputs() {
+			targets = append(targets, s)
+		}
+		if !s.propagate {
+			break
+		}
+	}
+	return targets
+}
+
+// writeAsync 在协程中把 data 写入各个输出，custom 为 true 时写入定制化控制台输出。
+// 写入登记在自己和各个 logger 的 pending 上，Flush 其中任何一个都会等待写入结束
+func (logger *CustomLogger) writeAsync(targets []*loggerState, data *bytes.Buffer, custom bool) {
+	groups := []*inflight{logger.pending}
+	for _, t := range targets {
+		if t.pending != logger.pending {
+			groups = append(groups, t.pending)
+		}
+	}
+	for _, g := range groups {
+		g.Add(1)
+	}
+	go func() {
+		for _, t := range targets {
+			if custom {
+				t.customStdout.Write(data.Bytes())
+			} else {
+				t.out.Write(data.Bytes())
+			}
+		}
+		PutBytesBuffer(data)
+		for _, g := range groups {
+			g.Done()
+		}
+	}()
+}
@@ -0,0 +1,163 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HttpConfig 通用 HTTP 输出配置，以 NDJSON(每行一条 json 记录) 格式 POST 到收集服务
+type HttpConfig struct {
+	Url        string            `json:"url,omitempty" yaml:"url,omitempty"`                 // 收集服务地址
+	Headers    map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`         // 附加请求头
+	Token      string            `json:"token,omitempty" yaml:"token,omitempty"`             // 不为空时添加 Authorization: Bearer <Token>
+	Gzip       bool              `json:"gzip,omitempty" yaml:"gzip,omitempty"`               // 是否使用 gzip 压缩请求体
+	BatchSize  int               `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`   // 批发条数，默认 1000
+	Linger     int64             `json:"linger,omitempty" yaml:"linger,omitempty"`           // 延时等待时间(秒)，默认 3
+	MaxRetries int               `json:"max_retries,omitempty" yaml:"max_retries,omitempty"` // 5xx/429/408 等错误时的重试次数，默认 3
+	Timeout    int               `json:"timeout,omitempty" yaml:"timeout,omitempty"`         // 请求超时时间(毫秒)，默认 3000
+	BufferPath string            `json:"buffer_path,omitempty" yaml:"buffer_path,omitempty"` // 发送失败时的缓存目录，默认 /data/http_buffer
+}
+
+// HttpHandle 批量 POST 到 HTTP 收集服务的输出，重试失败后写入本地缓存文件
+type HttpHandle struct {
+	conf   HttpConfig
+	client *http.Client
+	batch  *batchWriter
+}
+
+// 重试等待时间上限，避免 Retry-After 过大时长时间占用发送协程
+const httpMaxBackoff = 10 * time.Second
+
+// errHttpDrop 服务端拒绝且无法重试的记录，不写入缓存文件
+var errHttpDrop = errors.New("http record dropped")
+
+// DialHttp 创建 HTTP 输出
+func DialHttp(conf HttpConfig) (*HttpHandle, error) {
+	if conf.Url == "" {
+		return nil, errors.New("http url is empty")
+	}
+	if conf.MaxRetries <= 0 {
+		conf.MaxRetries = 3
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3000
+	}
+	if conf.BufferPath == "" {
+		conf.BufferPath = "/data/http_buffer"
+	}
+	H := &HttpHandle{
+		conf:   conf,
+		client: &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+	}
+	var err error
+	H.batch, err = newBatchWriter("http", conf.BufferPath, conf.BatchSize, conf.Linger, H.send)
+	if err != nil {
+		return nil, err
+	}
+	H.batch.start()
+	return H, nil
+}
+
+func (H *HttpHandle) Write(b []byte) (n int, err error) {
+	return H.WriteString(string(b))
+}
+
+func (H *HttpHandle) WriteString(msg string) (n int, err error) {
+	if !strings.HasSuffix(msg, "\n") {
+		msg = msg + "\n"
+	}
+	H.batch.put(msg)
+	return len(msg), nil
+}
+
+func (H *HttpHandle) Close() error {
+	H.batch.close()
+	return nil
+}
+
+func (H *HttpHandle) flushBatch(timeout time.Duration) {
+	H.batch.flush(timeout)
+}
+
+// send 发送一批记录，网络错误和 400、413、422 以外的错误状态退避重试，重试次数用完后返回错误，整批写入缓存文件；
+// 400、413、422 表示记录本身无法被接受，直接丢弃
+func (H *HttpHandle) send(b []byte) error {
+	body := b
+	if H.conf.Gzip {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(b)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		body = buf.Bytes()
+	}
+
+	var err error
+	for retry := 0; ; retry++ {
+		var wait time.Duration
+		wait, err = H.post(body)
+		if err == nil {
+			return nil
+		}
+		if wait < 0 || retry >= H.conf.MaxRetries {
+			break
+		}
+		if wait == 0 {
+			wait = time.Duration(200<<uint(retry)) * time.Millisecond
+		}
+		if wait > httpMaxBackoff {
+			wait = httpMaxBackoff
+		}
+		time.Sleep(wait)
+	}
+	if err == errHttpDrop {
+		return nil
+	}
+	return err
+}
+
+// post 发送一次请求。返回的等待时间为 0 表示使用默认退避，为负数表示不需要重试
+func (H *HttpHandle) post(body []byte) (time.Duration, error) {
+	req, err := http.NewRequest(http.MethodPost, H.conf.Url, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if H.conf.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if H.conf.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+H.conf.Token)
+	}
+	for k, v := range H.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := H.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return 0, nil
+	}
+	err = fmt.Errorf("http status %d: %s", resp.StatusCode, content)
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		// 请求体本身有问题，重试和重发都无法解决，直接丢弃
+		fmt.Fprintln(os.Stderr, "http drop records", err)
+		return -1, errHttpDrop
+	}
+	// 408、429、5xx 以及鉴权失败等其他错误可能恢复，退避重试，重试次数用完后写入缓存文件
+	seconds, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	return time.Duration(seconds) * time.Second, err
+}
@@ -0,0 +1,208 @@
+package navi_go_log
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level 日志等级，可以用在配置结构体和命令行参数中：
+//
+//	var level = nLog.Level(nLog.INFO)
+//	flag.Var(&level, "log-level", "DEBUG/INFO/WARNING/ERROR/CRITICAL")
+//	flag.Parse()
+//	nLog.Logger.SetLevel(int(level))
+//
+// ParseLevel 返回 Level，Log、SetLevel 等为兼容仍然接收 int
+type Level int
+
+// String 返回等级名，未注册的等级返回数字
+func (l Level) String() string {
+	if info, ok := levelInfo(l); ok {
+		return info.Name
+	}
+	return strconv.Itoa(int(l))
+}
+
+// MarshalText 输出等级名，json、yaml 中以字符串表示
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText 按 ParseLevel 的规则解析等级名
+func (l *Level) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// MarshalJSON 已注册的等级输出等级名，其他输出数字，保证可以再解析
+func (l Level) MarshalJSON() ([]byte, error) {
+	if _, ok := levelInfo(l); ok {
+		return json.Marshal(l.String())
+	}
+	return []byte(strconv.Itoa(int(l))), nil
+}
+
+// UnmarshalJSON 数字直接作为等级，兼容原来 int 类型的配置；字符串按 ParseLevel 解析
+func (l *Level) UnmarshalJSON(data []byte) error {
+	var n int
+	if err := json.Unmarshal(data, &n); err == nil {
+		*l = Level(n)
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return fmt.Errorf("%w: %s", NoMatchLogLevel, data)
+	}
+	return l.UnmarshalText([]byte(name))
+}
+
+// MarshalYAML 与 MarshalJSON 相同
+func (l Level) MarshalYAML() (interface{}, error) {
+	if _, ok := levelInfo(l); ok {
+		return l.String(), nil
+	}
+	return int(l), nil
+}
+
+// UnmarshalYAML 与 UnmarshalJSON 相同
+func (l *Level) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int
+	if err := unmarshal(&n); err == nil {
+		*l = Level(n)
+		return nil
+	}
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+	return l.UnmarshalText([]byte(name))
+}
+
+// Set 实现 flag.Value
+func (l *Level) Set(name string) error {
+	return l.UnmarshalText([]byte(name))
+}
+
+// LevelInfo 注册等级需要的信息，所有输出都从这里取等级名、简写、颜色和 syslog 优先级
+type LevelInfo struct {
+	Level      Level    // 等级值，如 TRACE 为 5、NOTICE 为 25
+	Name       string   // 等级名，统一转成大写，如 TRACE
+	Short      string   // 定制化控制台输出和简易日志中的简写，不能与其他等级重复
+	Color      int      // 控制台前景色，30-37，默认 37
+	Background int      // 控制台背景色，40-47，默认 40
+	Syslog     Priority // syslog 和 GELF 使用的优先级，为 0 时使用 LOG_INFO，不支持 LOG_EMERG
+}
+
+// levelTable 等级表，RegisterLevel 复制后整体替换，写日志时原子读取，不需要加锁
+type levelTable struct {
+	byName  map[string]Level
+	byLevel map[Level]LevelInfo
+}
+
+// currentLevels 当前的 *levelTable，初始为内置等级
+var currentLevels = func() *atomic.Value {
+	table := &levelTable{byName: make(map[string]Level), byLevel: make(map[Level]LevelInfo)}
+	for level, name := range LevelToName {
+		table.byName[name] = Level(level)
+		table.byLevel[Level(level)] = LevelInfo{
+			Level:      Level(level),
+			Name:       name,
+			Short:      CustomLevelToName[level],
+			Color:      LevelFrontColor[level],
+			Background: LevelBackgroundColor[level],
+			Syslog:     syslogLevM[name],
+		}
+	}
+	v := &atomic.Value{}
+	v.Store(table)
+	return v
+}()
+
+func loadLevels() *levelTable {
+	return currentLevels.Load().(*levelTable)
+}
+
+// levelInfo 返回已注册等级的信息
+func levelInfo(level Level) (LevelInfo, bool) {
+	info, ok := loadLevels().byLevel[level]
+	return info, ok
+}
+
+// levelPriority 返回等级名对应的 syslog 优先级，未注册时返回 LOG_INFO
+func levelPriority(name string) Priority {
+	table := loadLevels()
+	if level, ok := table.byName[name]; ok {
+		return table.byLevel[level].Syslog
+	}
+	return LOG_INFO
+}
+
+var levelLock sync.Mutex
+
+// RegisterLevel 注册自定义等级，如 TRACE、NOTICE，注册后可以在配置、环境变量和 ParseLevel 中使用。
+// 可以在其他协程写日志时调用，不能覆盖已有的等级：
+//
+//	nLog.RegisterLevel(nLog.LevelInfo{Level: 25, Name: "NOTICE", Short: "N", Color: 32, Syslog: nLog.LOG_NOTICE})
+//	nLog.Logger.Log(25, &nLog.LogRecord{Message: "config reloaded"})
+func RegisterLevel(info LevelInfo) error {
+	name := strings.ToUpper(strings.TrimSpace(info.Name))
+	if name == "" || info.Short == "" {
+		return fmt.Errorf("register level %d: name and short code are required", info.Level)
+	}
+	if info.Level <= 0 {
+		return fmt.Errorf("register level %s: level must be positive", name)
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return fmt.Errorf("register level %s: name can't be a number", name)
+	}
+	info.Name = name
+	if info.Color == 0 {
+		info.Color = 37
+	}
+	if info.Background == 0 {
+		info.Background = 40
+	}
+	if info.Syslog == 0 {
+		info.Syslog = LOG_INFO
+	}
+
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	old := loadLevels()
+	if _, ok := old.byName[name]; ok {
+		return fmt.Errorf("register level %s: name already registered", name)
+	}
+	if _, ok := levelAliases[name]; ok {
+		return fmt.Errorf("register level %s: name is an alias of %s", name, levelAliases[name])
+	}
+	if exist, ok := old.byLevel[info.Level]; ok {
+		return fmt.Errorf("register level %s: level %d already registered as %s", name, info.Level, exist.Name)
+	}
+	for _, exist := range old.byLevel {
+		if exist.Short == info.Short {
+			return fmt.Errorf("register level %s: short code %q already used by %s", name, info.Short, exist.Name)
+		}
+	}
+	table := &levelTable{
+		byName:  make(map[string]Level, len(old.byName)+1),
+		byLevel: make(map[Level]LevelInfo, len(old.byLevel)+1),
+	}
+	for k, v := range old.byName {
+		table.byName[k] = v
+	}
+	for k, v := range old.byLevel {
+		table.byLevel[k] = v
+	}
+	table.byName[name] = info.Level
+	table.byLevel[info.Level] = info
+	currentLevels.Store(table)
+	return nil
+}
@@ -1,6 +1,7 @@
 package navi_go_log
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -9,6 +10,7 @@ import (
 	"io"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 	// json "github.com/json-iterator/go"
 )
@@ -39,11 +41,20 @@ const (
 	newLine     = '\n'
 )
 const RFC3339 = "2006-01-02T15:04:05.999999+08:00"
+
+// 栈信息输出格式
+const (
+	StackFormatString = "string" // 只输出 stack_info 字符串
+	StackFormatJson   = "json"   // 只输出 stack_frames 数组
+	StackFormatBoth   = "both"   // 同时输出 stack_info 和 stack_frames
+)
 const RootLoggerName = "root_logger"
 const DefaultTag = "root"
 
 var GlobleStdLock = &sync.Mutex{}
 
+// Deprecated: 内置等级在包初始化时的冻结快照，之后不再同步：RegisterLevel 注册的等级不会出现在其中，
+// 对它的写入也不会影响日志输出。使用 ParseLevel、Level.String 和 RegisterLevel
 var NameToLevel = map[string]int{
 	"DEBUG":    DEBUG,
 	"INFO":     INFO,
@@ -54,6 +65,7 @@ var NameToLevel = map[string]int{
 	"FIXED":    FIXED,
 }
 
+// Deprecated: 冻结快照，见 NameToLevel
 var LevelToName = map[int]string{
 	DEBUG:    "DEBUG",
 	INFO:     "INFO",
@@ -64,6 +76,7 @@ var LevelToName = map[int]string{
 	FIXED:    "FIXED",
 }
 
+// Deprecated: 冻结快照，见 NameToLevel
 var CustomLevelToName = map[int]string{
 	DEBUG:    "D",
 	INFO:     "I",
@@ -71,7 +84,7 @@ var CustomLevelToName = map[int]string{
 	ERROR:    "E",
 	CRITICAL: "C",
 	FATAL:    "F",
-	FIXED:    "F",
+	FIXED:    "X",
 }
 
 // 前景 背景 颜色
@@ -94,6 +107,7 @@ var CustomLevelToName = map[int]string{
 //  7  反白显示
 //  8  不可见
 
+// Deprecated: 冻结快照，见 NameToLevel
 var LevelBackgroundColor = map[int]int{
 	DEBUG:    40,
 	INFO:     40,
@@ -104,6 +118,7 @@ var LevelBackgroundColor = map[int]int{
 	FIXED:    40,
 }
 
+// Deprecated: 冻结快照，见 NameToLevel
 var LevelFrontColor = map[int]int{
 	DEBUG:    37,
 	INFO:     36,
@@ -117,33 +132,51 @@ var LevelFrontColor = map[int]int{
 var NoMatchLogLevel = errors.New("can't match log level")
 
 // log 主体
+// 读取和修改配置请使用 GetLevel、SetLevel 等 Get、Set 方法，它们都可以在其他协程写日志时调用。
+// Level、FixedFlag、Tag、GlobalTag、StdoutFormat、SimpleLogStatus 是创建 logger 时的配置快照，
+// 之后的 Set 方法和 InitLogger 不会更新它们，对它们赋值也不会生效
 type CustomLogger struct {
-	Name            string
-	Level           int
-	FixedFlag       bool // 是否启用 FIXED 日志输出,默认是true
-	mu              *sync.Mutex
-	out             io.Writer
-	customStdout    io.Writer
-	Tag             []byte
-	CloserWriter    *SysLogHandle
-	GlobalTag       string
-	StdoutFormat    string
+	Name string
+	// Deprecated: 创建时的快照，不会更新，请使用 GetLevel 或 EffectiveLevel
+	Level int
+	// Deprecated: 创建时的快照，不会更新，请使用 GetFixedFlag
+	FixedFlag bool // 是否启用 FIXED 日志输出,默认是true
+	mu        *sync.Mutex
+	pending   *inflight     // 未完成的异步写入，见 Flush
+	state     *atomic.Value // *loggerState，写日志时读取的配置
+	// Deprecated: 创建时的快照，不会更新，请使用 GetTag
+	Tag          []byte
+	CloserWriter *SysLogHandle // ToElastic 的 syslog 输出，可能与其他 logger 共享，请使用 WriterClose 关闭
+	sinks        []LogHandle   // 所有输出，由注册表共享，见 acquireSink，由 mu 保护
+	// Deprecated: 创建时的快照，不会更新，请使用 GetGlobalTag
+	GlobalTag string
+	// Deprecated: 创建时的快照，不会更新，请使用 GetStdoutFormat
+	StdoutFormat string
+	// Deprecated: 创建时的快照，不会更新，请使用 GetSimpleLogStatus
 	SimpleLogStatus bool
+	callerSkip      int           // 额外跳过的调用层数，见 WithCallerSkip
+	effective       []ConfigValue // 最近一次 InitLogger 的生效配置，见 EffectiveConfig，由 mu 保护
+	conf            LoggerConfig  // 最近一次 InitLogger 应用的配置，重新加载时在此基础上合并，由 mu 保护
 }
 
 // 日志输出的字段，true表示可以在拓展字段中覆盖他
 var recordField = map[string]bool{
-	"level":      false,
-	"log_time":   true,
-	"filename":   false,
-	"moudle":     false,
-	"line_no":    false,
-	"func_name":  false,
-	"message":    false,
-	"tag":        false,
-	"trace_id":   false,
-	"exc_info":   false,
-	"stack_info": false,
+	"level":             false,
+	"log_time":          true,
+	"filename":          false,
+	"moudle":            false,
+	"line_no":           false,
+	"func_name":         false,
+	"message":           false,
+	"tag":               false,
+	"trace_id":          false,
+	"exc_info":          false,
+	"exc_type":          false,
+	"exc_chain":         false,
+	"error_fingerprint": false,
+	"stack_info":        false,
+	"stack_frames":      false,
+	"stack_multi":       false,
 }
 
 // 3 allocs/op
@@ -151,31 +184,41 @@ type ExtField map[string]interface{}
 
 // 允许设置的log内容
 type LogRecord struct {
-	Message string    `json:"message,omitempty"`
-	Tag     string    `json:"tag"`
-	TraceId string    `json:"trace_id,omitempty"`
-	ExcInfo string    `json:"exc_info,omitempty,string"`
-	Extra   *ExtField `json:"extra,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Tag     string          `json:"tag"`
+	TraceId string          `json:"trace_id,omitempty"`
+	ExcInfo string          `json:"exc_info,omitempty,string"`
+	Extra   *ExtField       `json:"extra,omitempty"`
+	Err     error           `json:"-"` // 错误对象，输出错误信息、类型和完整的错误链
+	Stacks  *Multi          `json:"-"` // 跨协程收集的调用栈，与日志调用处的栈一起输出
+	Context context.Context `json:"-"` // 携带调用栈的 context，见 ContextWithStacks，Stacks 为空时使用
 }
 
 // 是否直接调用log的标志,用来设置stack_skip层数的,自定义以便识别.
 type LogCallDepth int
 
+// Deprecated: 只是初始值，SetLogCallDepth 不会更新它，请使用 GetLogCallDepth
 var DefaultLogCallDepth LogCallDepth = 3
 
+// logCallDepth 默认的调用层数，写日志时原子读取，SetLogCallDepth 可以在其他协程写日志时调用
+var logCallDepth = int32(DefaultLogCallDepth)
+
 func SetLogCallDepth(depth int) {
-	DefaultLogCallDepth = LogCallDepth(depth)
+	atomic.StoreInt32(&logCallDepth, int32(depth))
+}
+
+// GetLogCallDepth 返回默认的调用层数
+func GetLogCallDepth() LogCallDepth {
+	return LogCallDepth(atomic.LoadInt32(&logCallDepth))
 }
 
 // LogLevel 设置日志等级
 func LogLevel(level int) error {
-	_, ok := LevelToName[level]
+	_, ok := levelInfo(Level(level))
 	if !ok {
 		return NoMatchLogLevel
 	}
-	if Logger.Level > 0 {
-		Logger.Level = level
-	}
+	std().SetLevel(level)
 	return nil
 }
 
@@ -196,7 +239,8 @@ func GetTime() string {
 // IsEnableLog 是否允许打印日志
 func (logger *CustomLogger) isEnableLog(level int) bool {
 	//logRecordNew := setFuncInfo(&logRecord,2)
-	return (level >= logger.Level) && (logger.FixedFlag || level < FIXED)
+	st := logger.load()
+	return (level >= st.effectiveLevel()) && (st.fixed || level < FIXED)
 	//return level >= logger.Level
 }
 
@@ -204,160 +248,285 @@ func (logger *CustomLogger) isEnableLog(level int) bool {
 func (logger *CustomLogger) SetWriter(writer []io.Writer) {
 	// 允许配置多个writer
 	if writer != nil {
-		logger.out = io.MultiWriter(writer...)
+		out := io.MultiWriter(writer...)
+		logger.update(func(st *loggerState) {
+			st.out = out
+			st.overrides |= fieldOut
+		})
 	}
 }
 
 func (logger *CustomLogger) SetCustomWriter(writer io.Writer) {
 	// 允许配置多个writer
 	if writer != nil {
-		logger.customStdout = writer
+		logger.update(func(st *loggerState) {
+			st.customStdout = writer
+			st.overrides |= fieldOut
+		})
 	}
 }
 
+// SetStdoutFormat 设置控制台输出格式，只影响自己的控制台输出(InitLogger 或 SetCustomWriter 设置的输出)
 func (logger *CustomLogger) SetStdoutFormat(stdoutFormat string) {
-	if stdoutFormat == "custom" {
-		logger.StdoutFormat = "custom"
-	} else {
-		logger.StdoutFormat = "json"
+	if stdoutFormat != "custom" {
+		stdoutFormat = "json"
 	}
+	logger.update(func(st *loggerState) { st.stdoutFormat = stdoutFormat })
 }
 
 func (logger *CustomLogger) SetSimpleLogStatus(status bool) {
-	logger.SimpleLogStatus = status
+	logger.update(func(st *loggerState) {
+		st.simpleLog = status
+		st.overrides |= fieldSimpleLog
+	})
 }
 
-// InitLogger 设置日志输出到标志输出
-func (logger *CustomLogger) InitLogger(loggerConfig *LoggerConfig) (err error) {
-	// loggerName is global_tag
-	// 环境变量优先级最高
-	envToStdout := os.Getenv("LOG_TO_STDOUT")
-	envToSyslog := os.Getenv("LOG_TO_SYSLOG")
-	envIP := os.Getenv("LOG_SERVER_IP")
-	envPort := os.Getenv("LOG_SERVER_PORT")
-	envLevel := os.Getenv("LOG_OUT_LEVEL")
-	envStdoutFormat := os.Getenv("STDOUT_FORMAT")
-	envSimpleLogOn := os.Getenv("SIMPLE_LOG_ON")
-
-	// 检查环境变量
-	if envToStdout == "YES" {
-		loggerConfig.ToStdout = true
-	} else if envToStdout == "NO" {
-		loggerConfig.ToStdout = false
-	}
-
-	if envToSyslog == "YES" {
-		loggerConfig.ToElastic = true
-	} else if envToSyslog == "NO" {
-		loggerConfig.ToElastic = false
+// SetStackFormat 设置栈信息输出格式：string(默认)、json 或 both。
+// json 格式把栈信息输出为 stack_frames 字段，形如 [{"func":"main","file":"main.go","line":10}]
+func (logger *CustomLogger) SetStackFormat(stackFormat string) {
+	switch stackFormat {
+	case StackFormatJson, StackFormatBoth:
+	default:
+		stackFormat = StackFormatString
 	}
+	logger.update(func(st *loggerState) {
+		st.stackFormat = stackFormat
+		st.overrides |= fieldStackFormat
+	})
+}
 
-	if envIP != "" {
-		loggerConfig.LogServerIp = envIP
-	}
+// SetStackDepth 设置栈信息最大深度，小于等于0时使用默认的32层。
+// 没有调用过 InitLogger 时，策略的其他字段取自上级当前的策略
+func (logger *CustomLogger) SetStackDepth(depth int) {
+	logger.update(func(st *loggerState) {
+		st.stackPolicy = st.sourceOf(fieldStackPolicy).stackPolicy
+		st.stackPolicy.MaxDepth = depth
+		st.overrides |= fieldStackPolicy
+	})
+}
 
-	if envPort != "" {
-		loggerConfig.LogServerPort = envPort
+// InitLogger 设置日志输出到标志输出
+func (logger *CustomLogger) InitLogger(loggerConfig *LoggerConfig) (err error) {
+	// loggerName is global_tag
+	// 环境变量优先级最高，变量名见 settings
+	code := setKeys(loggerConfig)
+	env, err := bindEnv(loggerConfig, "")
+	if err != nil {
+		return err
 	}
 
-	if envLevel != "" {
-		loggerConfig.LogLevel = envLevel
+	// 配置有误时不修改当前的配置和输出
+	if err = loggerConfig.Validate(); err != nil {
+		return err
 	}
-
-	if envStdoutFormat != "" {
-		loggerConfig.StdoutFormat = envStdoutFormat
+	level, _ := ParseLevel(loggerConfig.LogLevel)
+	if override, ok := loggerConfig.Levels[logger.Name]; ok {
+		level, _ = ParseLevel(override)
 	}
 
-	if envSimpleLogOn == "YES" {
-		loggerConfig.SimpleLogStatus = true
-	} else if envSimpleLogOn == "NO" {
-		loggerConfig.SimpleLogStatus = false
+	metaFields, metaGoroutine, err := metadataFields(loggerConfig.MetadataFields)
+	if err != nil {
+		return err
 	}
 
+	lock.Lock()
 	if logger.Name == RootLoggerName {
 		GlobalConf = *loggerConfig
 	}
-
 	if loggerConfig.LoggerName == "" && GlobalConf.LoggerName != "" {
 		loggerConfig.LoggerName = GlobalConf.LoggerName
 	}
-	logger.GlobalTag = loggerConfig.LoggerName
+	lock.Unlock()
 
-	var writers []io.Writer
-	var oldSyslog *SysLogHandle
+	sinks, err := newSinks(loggerConfig)
+	if err != nil {
+		return err
+	}
+
+	var syslogHandle *SysLogHandle
 	if loggerConfig.ToElastic {
-		mySysHandler, err = Dial("tcp", loggerConfig.LogServerIp+":"+loggerConfig.LogServerPort, syslogLevM[loggerConfig.LogLevel])
+		sysConf := loggerConfig.Syslog.withEnvDefaults()
+		sysConf.Addr = loggerConfig.LogServerIp + ":" + loggerConfig.LogServerPort
+		priority := levelPriority(Level(level).String())
+		key := sinkKey(SinkSyslog, loggerConfig.SinkInstance, []interface{}{sysConf, priority})
+		sink, err := acquireSink(key, func() (LogHandle, error) {
+			return dialSyslog(sysConf, priority)
+		})
 		if err != nil {
-			panic(err)
-		}
-		if logger.CloserWriter != nil {
-			oldSyslog = logger.CloserWriter
+			for _, sink := range sinks {
+				sink.Close()
+			}
+			return err
 		}
-		logger.CloserWriter = mySysHandler
-		writers = append(writers, mySysHandler)
+		syslogHandle = sink.entry.handle.(*SysLogHandle)
+		sinks = append([]LogHandle{sink}, sinks...)
 	}
-	if loggerConfig.ToStdout {
+	var writers []io.Writer
+	for _, sink := range sinks {
+		writers = append(writers, sink)
+	}
+	stdoutFormat := "json"
+	if loggerConfig.StdoutFormat == "custom" {
+		stdoutFormat = "custom"
+	}
+	if loggerConfig.ToStdout && stdoutFormat == "json" {
 		// writers = append(writers, GetLockWriter(os.Stdout, GlobleStdLock))
-		logger.SetStdoutFormat(loggerConfig.StdoutFormat)
-		logger.SetSimpleLogStatus(loggerConfig.SimpleLogStatus)
-		if logger.StdoutFormat == "custom" {
-			logger.SetCustomWriter(os.Stdout)
+		writers = append(writers, os.Stdout)
+	}
+	stackFormat := loggerConfig.StackFormat
+	if stackFormat != StackFormatJson && stackFormat != StackFormatBoth {
+		stackFormat = StackFormatString
+	}
+	stackPolicy := DefaultStackPolicy()
+	if loggerConfig.StackPolicy != nil {
+		stackPolicy = *loggerConfig.StackPolicy
+	}
+	if loggerConfig.StackDepth > 0 {
+		stackPolicy.MaxDepth = loggerConfig.StackDepth
+	}
+	effective := effectiveConfig(loggerConfig, env, code)
+
+	// 一次替换全部配置，其他协程不会看到只更新了一部分的配置
+	var oldSinks []LogHandle
+	logger.update(func(st *loggerState) {
+		oldSinks = logger.sinks
+		logger.sinks = sinks
+		logger.CloserWriter = syslogHandle
+		logger.effective = effective
+		logger.conf = *loggerConfig
+		st.level, st.levelSet = int(level), true
+		st.globalTag = loggerConfig.LoggerName
+		st.metaFields, st.metaGoroutine = metaFields, metaGoroutine
+		st.out = nil
+		if len(writers) > 0 {
+			st.out = io.MultiWriter(writers...)
+		}
+		// 重新加载时可能关闭了控制台输出或改变了格式，不保留原来的定制化控制台输出
+		st.customStdout = nil
+		if loggerConfig.ToStdout {
+			st.stdoutFormat = stdoutFormat
+			st.simpleLog = loggerConfig.SimpleLogStatus
+			if stdoutFormat == "custom" {
+				st.customStdout = os.Stdout
+			}
 		} else {
-			writers = append(writers, os.Stdout)
+			st.stdoutFormat, st.simpleLog = "", false
 		}
+		st.stackFormat = stackFormat
+		st.stackPolicy = stackPolicy
+		st.configured = true
+		if st.parent != nil {
+			st.propagate = loggerConfig.Propagate
+		}
+	})
+	// 等待仍在写旧输出的协程结束后再释放旧输出，其他 logger 仍在使用的输出不会关闭
+	if len(oldSinks) > 0 {
+		logger.Flush()
 	}
-	logger.SetLevel(defaultLevM[loggerConfig.LogLevel])
-	logger.SetWriter(writers)
-	if oldSyslog != nil {
-		oldSyslog.Close()
+	for _, sink := range oldSinks {
+		sink.Close()
 	}
 	return nil
 }
 
+// newSinks 按配置创建 syslog 以外的输出，任何一个创建失败时关闭已创建的输出
+func newSinks(loggerConfig *LoggerConfig) (sinks []LogHandle, err error) {
+	defer func() {
+		if err != nil {
+			for _, sink := range sinks {
+				sink.Close()
+			}
+			sinks = nil
+		}
+	}()
+	var legacy []SinkConfig
+	if loggerConfig.ToGelf {
+		legacy = append(legacy, SinkConfig{Type: SinkGelf, Gelf: &loggerConfig.Gelf})
+	}
+	if loggerConfig.ToElasticBulk {
+		legacy = append(legacy, SinkConfig{Type: SinkElastic, Elastic: &loggerConfig.ElasticBulk})
+	}
+	if loggerConfig.ToLoki {
+		legacy = append(legacy, SinkConfig{Type: SinkLoki, Loki: &loggerConfig.Loki})
+	}
+	if loggerConfig.ToHttp {
+		legacy = append(legacy, SinkConfig{Type: SinkHttp, Http: &loggerConfig.Http})
+	}
+	for _, sinkConfig := range legacy {
+		sink, err := dialSink(sinkConfig, loggerConfig)
+		if err != nil {
+			return sinks, err
+		}
+		sinks = append(sinks, sink)
+	}
+	for i, sinkConfig := range loggerConfig.Sinks {
+		sink, err := dialSink(sinkConfig, loggerConfig)
+		if err != nil {
+			return sinks, fmt.Errorf("sinks[%d]: %v", i, err)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
 // SetLevel 设置日志输出等级
-func (logger *CustomLogger) SetLevel(Level int) {
-	if _, ok := LevelToName[Level]; ok {
-		logger.Level = Level
-	} else {
-		logger.Level = INFO
+func (logger *CustomLogger) SetLevel(level int) {
+	if _, ok := levelInfo(Level(level)); !ok {
+		level = INFO
 	}
+	logger.update(func(st *loggerState) {
+		st.level, st.levelSet = level, true
+	})
 }
 
 // SetDefaultTag 设置默认TAG
 func (logger *CustomLogger) SetDefaultTag(tag string) {
+	var encoded []byte
 	if tag != "" {
-		logger.Tag = EncodeString(tag, false)
-	} else {
-		logger.Tag = nil
+		encoded = EncodeString(tag, false)
 	}
+	logger.update(func(st *loggerState) {
+		st.tag, st.tagName = encoded, tag
+	})
 }
 
 // SetGlobalTag 设置 Global Tag
 func (logger *CustomLogger) SetGlobalTag(globalTag string) {
-	logger.GlobalTag = globalTag
+	logger.update(func(st *loggerState) {
+		st.globalTag = globalTag
+		st.overrides |= fieldGlobalTag
+	})
 }
 
 // SetFixedFlag 是否启用 FIXED 日志输出,默认是true
 func (logger *CustomLogger) SetFixedFlag(flag bool) {
-	logger.FixedFlag = flag
+	logger.update(func(st *loggerState) { st.fixed = flag })
 }
 
-// WriterClose  关闭Writer
+// WriterClose  关闭Writer，与其他 logger 共享的输出在最后一个使用者关闭时才关闭
 func (logger *CustomLogger) WriterClose() {
-	if logger.CloserWriter != nil {
-		logger.CloserWriter.Close()
+	logger.mu.Lock()
+	sinks := logger.sinks
+	logger.mu.Unlock()
+	for _, sink := range sinks {
+		sink.Close()
 	}
 }
 
 func (logger *CustomLogger) SimpleLog(level int, msg string, extend ...interface{}) {
+	if level == FATAL {
+		defer logger.fatal()
+	}
 	if !logger.isEnableLog(level) {
 		return
 	}
-	if !logger.SimpleLogStatus {
+	st := logger.load()
+	simple := st.sourceOf(fieldSimpleLog).simpleLog
+	if !simple && len(st.observers()) == 0 {
 		return
 	}
 
-	stackSkip := DefaultLogCallDepth
+	stackSkip := GetLogCallDepth()
 	//stackSkip := LogCallDepth(4)
 	// 判断是否是直接调用log，非直接调用log的，需要设置一下skip参数，用于栈信息的获取
 	for _, v := range extend {
@@ -366,28 +535,34 @@ func (logger *CustomLogger) SimpleLog(level int, msg string, extend ...interface
 			stackSkip = v.(LogCallDepth)
 		}
 	}
+	stackSkip += LogCallDepth(logger.callerSkip)
 	// using map
 	//设置函数调用信息
-	var filename string
-	var lineNo int
-
-	// 设置错误栈信息,level 为 FIXED 时，也不记录
-	// 300000	      4680 ns/op	    1200 B/op	       9 allocs/op
-	if level >= CRITICAL && level != FIXED {
-		// 3600 ns/op 10 allocs/op
-		// 1000000	      2583 ns/op	     208 B/op	       1 allocs/op
-		_, filename, _, _, lineNo = CallersWithFirstCallInfo(int(stackSkip) - 1)
-	} else {
-		filename, _, _, lineNo = setFuncInfo(int(stackSkip))
+	// 简易日志不输出栈信息，只需要文件名和行号
+	filename, _, _, lineNo := setFuncInfo(int(stackSkip))
+	// 与 Log 一致，观察者在输出判断之前记录，未开启简易日志时也能断言
+	logger.observe(st, level, &LogRecord{Message: msg}, "", "", filename, "", "", lineNo)
+	if !simple {
+		return
+	}
+	var targets []*loggerState
+	for _, t := range st.outputs() {
+		if t.customStdout != nil {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return
 	}
 	data := GetBytesBuffer()
 	simpleLogTime := fmt.Sprintf("%s", GetTime())
-	simpleLevel := fmt.Sprintf("%c[%d;%d;%dm%s%s%s%c[0m", 0x1B, 1, LevelBackgroundColor[level],
-		LevelFrontColor[level], "[", CustomLevelToName[level], "]", 0x1B)
+	info, _ := levelInfo(Level(level))
+	simpleLevel := fmt.Sprintf("%c[%d;%d;%dm%s%s%s%c[0m", 0x1B, 1, info.Background,
+		info.Color, "[", info.Short, "]", 0x1B)
 	simpleMessage := fmt.Sprintf("%c[%d;%d;%dm%s%c[0m", 0x1B, 1, 0,
-		LevelFrontColor[level], msg, 0x1B)
+		info.Color, msg, 0x1B)
 	filenameAndLineNo := fmt.Sprintf("%c[%d;%d;%dm%s%s:%d%s%c[0m", 0x1B, 1, 0,
-		LevelFrontColor[level], "[", filename, lineNo,"]",  0x1B)
+		info.Color, "[", filename, lineNo,"]",  0x1B)
 
 	simpleLog := fmt.Sprintf("%s %s %s ▶ %s\n",
 		simpleLogTime,
@@ -397,14 +572,15 @@ func (logger *CustomLogger) SimpleLog(level int, msg string, extend ...interface
 	)
 
 	data.WriteString(simpleLog)
-	go func() {
-		logger.customStdoutWrite(data.Bytes())
-		PutBytesBuffer(data)
-	}()
+	logger.writeAsync(targets, data, true)
 }
 
 // Log 日志记录，手动写入bytes,效率更快，有待完整测试
 func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...interface{}) {
+	if level == FATAL {
+		// 写出后按 FatalPolicy 等待写入完成并退出，见 fatal.go
+		defer logger.fatal()
+	}
 	if !logger.isEnableLog(level) {
 		return
 	}
@@ -413,43 +589,110 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 
 	// // 1 allocs/op
 	//stackSkip := LogCallDepth(4)
-	stackSkip := DefaultLogCallDepth
+	stackSkip := GetLogCallDepth()
+	var pcs callerPCs
 	// 判断是否是直接调用log，非直接调用log的，需要设置一下skip参数，用于栈信息的获取
 	for _, v := range extend {
 		switch v.(type) {
 		case LogCallDepth:
 			stackSkip = v.(LogCallDepth)
+		case callerPCs:
+			pcs = v.(callerPCs)
 		}
 	}
+	stackSkip += LogCallDepth(logger.callerSkip)
 	// using map
 	//设置函数调用信息
 	var filename, module, funcName, stackInfo string
 	var lineNo int
+	var stack, topStack Stack
 
-	// 设置错误栈信息,level 为 FIXED 时，也不记录
+	// 按策略设置错误栈信息,level 为 FIXED 时，也不记录
 	// 300000	      4680 ns/op	    1200 B/op	       9 allocs/op
-	if level >= CRITICAL && level != FIXED {
+	// 没有调用过 InitLogger 时使用上级的配置
+	st := logger.load()
+	stackPolicy := &st.sourceOf(fieldStackPolicy).stackPolicy
+	stackFormat := st.sourceOf(fieldStackFormat).stackFormat
+	// 记录携带跨协程的调用栈时，总是获取日志调用处的栈
+	multi := recordStacks(logRecord)
+	if len(pcs) > 0 {
+		// 使用调用方传入的调用栈，如 panic 发生时的栈
+		stack, filename, module, funcName, lineNo = framesWithFirstCallInfo(pcs, stackPolicy.MaxDepth, stackPolicy.SkipRuntime)
+		stackInfo = stack.String()
+	} else if multi != nil || stackPolicy.needStack(level, logRecord) {
 		// 3600 ns/op 10 allocs/op
 		// 1000000	      2583 ns/op	     208 B/op	       1 allocs/op
-		stackInfo, filename, module, funcName, lineNo = CallersWithFirstCallInfo(int(stackSkip) - 1)
+		stack, filename, module, funcName, lineNo = callersWithFirstCallInfo(int(stackSkip)-1, stackPolicy.MaxDepth, stackPolicy.SkipRuntime)
+		stackInfo = stack.String()
+		if level == FATAL && stackPolicy.AllGoroutinesOnFatal {
+			stackInfo = stackTrace(true)
+		}
+	} else if level >= ERROR && level < FIXED {
+		// 不输出栈信息，只取计算 error_fingerprint 需要的几层调用栈
+		topStack, filename, module, funcName, lineNo = callersWithFirstCallInfo(int(stackSkip)-1, fingerprintDepth, stackPolicy.SkipRuntime)
 	} else {
 		filename, module, funcName, lineNo = setFuncInfo(int(stackSkip))
 	}
 
+	// 传入 error 时，exc_info 默认为错误信息，错误自带栈信息时使用它作为 stack_info
+	excInfo := logRecord.ExcInfo
+	if logRecord.Err != nil {
+		if excInfo == "" {
+			excInfo = errorMessage(logRecord.Err)
+		}
+		if errStack := errorStack(logRecord.Err); len(errStack) > 0 {
+			stack = errStack
+			stackInfo = stack.String()
+		}
+	}
+	// 跨协程的调用栈在前，日志调用处的栈在后
+	if multi != nil {
+		multi = multi.Copy()
+		multi.Add(stack)
+		stackInfo = multi.String()
+	}
+
+	// ERROR 及以上等级计算错误指纹，用于聚合相同的错误
+	var fingerprint string
+	if level >= ERROR && level < FIXED {
+		var errType string
+		if logRecord.Err != nil {
+			errType = errorType(logRecord.Err)
+		}
+		if len(stack) > 0 {
+			topStack = stack
+		}
+		fingerprint = errorFingerprint(topStack, errType, logRecord.Message)
+	}
+	logger.observe(st, level, logRecord, stackInfo, fingerprint, filename, module, funcName, lineNo)
+
+	targets := st.outputs()
+	var customTargets, jsonTargets []*loggerState
+	for _, t := range targets {
+		if t.stdoutFormat == "custom" && t.customStdout != nil {
+			customTargets = append(customTargets, t)
+		}
+		if t.out != nil {
+			jsonTargets = append(jsonTargets, t)
+		}
+	}
+
 	// 控制台日志定制化输出
-	if logger.StdoutFormat == "custom"{
+	if len(customTargets) > 0 {
 		customData := GetBytesBuffer()
 		customLogTime := fmt.Sprintf("%s", GetTime())
-		customLevel := fmt.Sprintf("%c[%d;%d;%dm%s%s%s%c[0m", 0x1B, 1, LevelBackgroundColor[level],
-			LevelFrontColor[level], "[", CustomLevelToName[level], "]", 0x1B)
+		info, _ := levelInfo(Level(level))
+		customLevel := fmt.Sprintf("%c[%d;%d;%dm%s%s%s%c[0m", 0x1B, 1, info.Background,
+			info.Color, "[", info.Short, "]", 0x1B)
 		//customMessage := fmt.Sprintf("%v", logRecord.Message)
 		customMessage := fmt.Sprintf("%c[%d;%d;%dm%s%c[0m", 0x1B, 1, 0,
-			LevelFrontColor[level], logRecord.Message, 0x1B)
+			info.Color, logRecord.Message, 0x1B)
 		filenameAndLineNo := fmt.Sprintf("%c[%d;%d;%dm%s%s:%d%s%c[0m", 0x1B, 1, 0,
-			LevelFrontColor[level], "[", filename, lineNo,"]", 0x1B)
+			info.Color, "[", filename, lineNo,"]", 0x1B)
 
-		if stackInfo != "" {
-			stackInfo = stackInfo + "\n"
+		customStack := stackInfo
+		if customStack != "" {
+			customStack = customStack + "\n"
 		}
 
 		customLog := fmt.Sprintf("%s %s %s ▶ %s %s\n%s",
@@ -457,18 +700,16 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 			customLevel,
 			filenameAndLineNo,
 			customMessage,
-			logRecord.ExcInfo,
-			stackInfo)
+			excInfo,
+			customStack)
 
 		customData.WriteString(customLog)
-		go func() {
-			logger.customStdoutWrite(customData.Bytes())
-			PutBytesBuffer(customData)
-		}()
-		// json格式输出的write（无论是stdout还是rsyslog）如果为空，则不应该再往下走
-		if logger.out == nil{
-			return
-		}
+		logger.writeAsync(customTargets, customData, true)
+	}
+
+	// json格式输出的write（无论是stdout还是rsyslog）如果为空，则不应该再往下走
+	if len(jsonTargets) == 0 {
+		return
 	}
 
 	// json格式输出
@@ -481,7 +722,7 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 	data.WriteString("@global_tag")
 	data.WriteString(`":"`)
 
-	data.WriteString(logger.GlobalTag)
+	data.WriteString(st.sourceOf(fieldGlobalTag).globalTag)
 	data.WriteByte('"')
 
 	// 设置log级别 level_name
@@ -489,7 +730,7 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 	data.WriteByte('"')
 	data.WriteString("level_name")
 	data.WriteString(`":"`)
-	data.WriteString(LevelToName[level])
+	data.WriteString(Level(level).String())
 	data.WriteByte('"')
 
 	// 日志记录时间 log_time
@@ -546,7 +787,7 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 	data.Write(EncodeString(logRecord.Message, false))
 
 	// 栈信息 stackInfo
-	if stackInfo != "" {
+	if stackInfo != "" && stackFormat != StackFormatJson {
 		data.WriteByte(',')
 		data.WriteByte('"')
 		data.WriteString("stack_info")
@@ -554,13 +795,53 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 		// 300000	      4236 ns/op	    1288 B/op	      10 allocs/op
 		data.Write(EncodeString(stackInfo, false))
 	}
+	// 结构化栈信息 stack_frames
+	if len(stack) > 0 && (stackFormat == StackFormatJson || stackFormat == StackFormatBoth) {
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString("stack_frames")
+		data.WriteString(`":`)
+		writeStackJSON(data, stack)
+	}
+	// 跨协程的全部调用栈 stack_multi
+	if multi != nil && (stackFormat == StackFormatJson || stackFormat == StackFormatBoth) {
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString("stack_multi")
+		data.WriteString(`":`)
+		writeMultiJSON(data, multi)
+	}
 	// 错误信息 exc_info
-	if logRecord.ExcInfo != "" {
+	if excInfo != "" {
 		data.WriteByte(',')
 		data.WriteByte('"')
 		data.WriteString("exc_info")
 		data.WriteString(`":`)
-		data.Write(EncodeString(logRecord.ExcInfo, false))
+		data.Write(EncodeString(excInfo, false))
+	}
+	// 错误类型 exc_type 和错误链 exc_chain
+	if logRecord.Err != nil {
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString("exc_type")
+		data.WriteString(`":`)
+		data.Write(EncodeString(errorType(logRecord.Err), false))
+
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString("exc_chain")
+		data.WriteString(`":`)
+		writeErrorChainJSON(data, errorChain(logRecord.Err))
+	}
+
+	// 错误指纹 error_fingerprint
+	if fingerprint != "" {
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString("error_fingerprint")
+		data.WriteString(`":"`)
+		data.WriteString(fingerprint)
+		data.WriteByte('"')
 	}
 
 	// 写入trace_id
@@ -573,18 +854,25 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 		data.WriteByte('"')
 	}
 	// 写入tag
-	if logRecord.Tag != "" || logger.Tag != nil {
+	tag := st.tag
+	if tag == nil {
+		tag = st.source().tag
+	}
+	if logRecord.Tag != "" || tag != nil {
 		data.WriteByte(',')
 		data.WriteByte('"')
 		data.WriteString("tag")
 		data.WriteString(`":`)
 		if logRecord.Tag != "" {
 			data.Write(EncodeString(logRecord.Tag, false))
-		} else if logger.Tag != nil {
-			data.Write(logger.Tag)
+		} else {
+			data.Write(tag)
 		}
 	}
 
+	// 进程和运行环境字段
+	st.sourceOf(fieldMetadata).writeMetadata(data, logRecord.Extra)
+
 	// 添加拓展字段的信息
 	if logRecord.Extra != nil {
 		for k, v := range *logRecord.Extra {
@@ -609,49 +897,42 @@ func (logger *CustomLogger) Log(level int, logRecord *LogRecord, extend ...inter
 	data.WriteByte('}')
 	data.WriteByte('\n')
 
-	go func() {
-		logger.write(data.Bytes())
-		PutBytesBuffer(data)
-	}()
+	logger.writeAsync(jsonTargets, data, false)
 
 }
 
-func (logger *CustomLogger) write(data []byte) {
-	// logger.mu.Lock()
-	logger.out.Write(data)
-	// logger.mu.Unlock()
+// Flush 等待已提交的异步写入完成，用于程序退出或 panic 前保证日志写出。
+// 只等待写入各输出的调用返回，syslog 等批量输出的缓存队列需要 WriterClose 才会发送完。
+func (logger *CustomLogger) Flush() {
+	logger.pending.Wait()
 }
 
-func (logger *CustomLogger) customStdoutWrite(data []byte) {
-	// logger.mu.Lock()
-	logger.customStdout.Write(data)
-	// logger.mu.Unlock()
-}
 
 func (logger *CustomLogger) Debug(logRecord *LogRecord) {
-	logger.Log(DEBUG, logRecord, DefaultLogCallDepth)
+	logger.Log(DEBUG, logRecord, GetLogCallDepth())
 }
 
 func (logger *CustomLogger) Info(logRecord *LogRecord) {
-	logger.Log(INFO, logRecord, DefaultLogCallDepth)
+	logger.Log(INFO, logRecord, GetLogCallDepth())
 }
 
 func (logger *CustomLogger) Warning(logRecord *LogRecord) {
-	logger.Log(WARNING, logRecord, DefaultLogCallDepth)
+	logger.Log(WARNING, logRecord, GetLogCallDepth())
 }
 
 func (logger *CustomLogger) Error(logRecord *LogRecord) {
-	logger.Log(ERROR, logRecord, DefaultLogCallDepth)
+	logger.Log(ERROR, logRecord, GetLogCallDepth())
 }
 
 func (logger *CustomLogger) Critical(logRecord *LogRecord) {
-	logger.Log(CRITICAL, logRecord, DefaultLogCallDepth)
+	logger.Log(CRITICAL, logRecord, GetLogCallDepth())
 }
 
+// Fatal 与 nLog.Fatal 一致，写出后按 FatalPolicy 退出
 func (logger *CustomLogger) Fatal(logRecord *LogRecord) {
-	logger.Log(FATAL, logRecord, DefaultLogCallDepth)
+	logger.Log(FATAL, logRecord, GetLogCallDepth())
 }
 
 func (logger *CustomLogger) Fixed(logRecord *LogRecord) {
-	logger.Log(FIXED, logRecord, DefaultLogCallDepth)
+	logger.Log(FIXED, logRecord, GetLogCallDepth())
 }
@@ -2,121 +2,131 @@ package navi_go_log
 
 import (
 	"fmt"
-	"os"
-	"time"
 )
 
 func Debug(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(DEBUG, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(DEBUG, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(DEBUG, v.(string), args...)
+	case error:
+		logErr(DEBUG, v.(error), args...)
 	default:
-		Logger.SimpleLog(DEBUG, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(DEBUG, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
 }
 
 func Debugf(format string, args ...interface{}) {
-	Logger.SimpleLog(DEBUG, fmt.Sprintf(format, args...), DefaultLogCallDepth)
+	std().SimpleLog(DEBUG, fmt.Sprintf(format, args...), GetLogCallDepth())
 }
 
 func Info(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(INFO, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(INFO, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(INFO, v.(string), args...)
+	case error:
+		logErr(INFO, v.(error), args...)
 	default:
-		Logger.SimpleLog(INFO, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(INFO, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
 }
 
 func Infof(format string, args ...interface{}) {
 	if args != nil && len(args) > 0 {
-		Logger.SimpleLog(INFO, fmt.Sprintf(format, args...), DefaultLogCallDepth)
+		std().SimpleLog(INFO, fmt.Sprintf(format, args...), GetLogCallDepth())
 	} else {
-		Logger.SimpleLog(INFO, fmt.Sprintf(format), DefaultLogCallDepth)
+		std().SimpleLog(INFO, fmt.Sprintf(format), GetLogCallDepth())
 	}
 }
 
 func Warning(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(WARNING, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(WARNING, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(WARNING, v.(string), args...)
+	case error:
+		logErr(WARNING, v.(error), args...)
 	default:
-		Logger.SimpleLog(WARNING, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(WARNING, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
 }
 
 func Warningf(format string, args ...interface{}) {
-	Logger.SimpleLog(WARNING, fmt.Sprintf(format, args...), DefaultLogCallDepth)
+	std().SimpleLog(WARNING, fmt.Sprintf(format, args...), GetLogCallDepth())
 }
 
 func Error(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(ERROR, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(ERROR, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(ERROR, v.(string), args...)
+	case error:
+		logErr(ERROR, v.(error), args...)
 	default:
-		Logger.SimpleLog(ERROR, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(ERROR, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
 }
 
 func Errorf(format string, args ...interface{}) {
-	Logger.SimpleLog(ERROR, fmt.Sprintf(format, args...), DefaultLogCallDepth)
+	std().SimpleLog(ERROR, fmt.Sprintf(format, args...), GetLogCallDepth())
 }
 
 func Critical(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(CRITICAL, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(CRITICAL, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(CRITICAL, v.(string), args...)
+	case error:
+		logErr(CRITICAL, v.(error), args...)
 	default:
-		Logger.SimpleLog(CRITICAL, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(CRITICAL, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
 }
 
 func Criticalf(format string, args ...interface{}) {
-	Logger.SimpleLog(CRITICAL, fmt.Sprintf(format, args...), DefaultLogCallDepth)
+	std().SimpleLog(CRITICAL, fmt.Sprintf(format, args...), GetLogCallDepth())
 }
 
+// Fatal 写出日志后按 FatalPolicy 等待写入完成、执行退出钩子并退出，见 SetFatalPolicy
 func Fatal(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(FATAL, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(FATAL, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(FATAL, v.(string), args...)
+	case error:
+		logErr(FATAL, v.(error), args...)
 	default:
-		Logger.SimpleLog(FATAL, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(FATAL, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
-	time.Sleep(time.Second * 4)
-	os.Exit(1)
 }
 
+// Fatalf 写出日志后按 FatalPolicy 退出
 func Fatalf(format string, args ...interface{}) {
-	Logger.SimpleLog(FATAL, fmt.Sprintf(format, args...), DefaultLogCallDepth)
-	time.Sleep(time.Second * 4)
-	os.Exit(1)
+	std().SimpleLog(FATAL, fmt.Sprintf(format, args...), GetLogCallDepth())
 }
 
 func Fixed(v interface{}, args ...interface{}) {
 	switch v.(type) {
 	case *LogRecord:
-		Logger.Log(FIXED, v.(*LogRecord), DefaultLogCallDepth)
+		std().Log(FIXED, v.(*LogRecord), GetLogCallDepth())
 	case string:
 		logMsg(FIXED, v.(string), args...)
+	case error:
+		logErr(FIXED, v.(error), args...)
 	default:
-		Logger.SimpleLog(FIXED, fmt.Sprintf("%v", v), DefaultLogCallDepth)
+		std().SimpleLog(FIXED, fmt.Sprintf("%v", v), GetLogCallDepth())
 	}
 }
 
 func Fixedf(format string, args ...interface{}) {
-	Logger.SimpleLog(FIXED, fmt.Sprintf(format, args...), DefaultLogCallDepth)
+	std().SimpleLog(FIXED, fmt.Sprintf(format, args...), GetLogCallDepth())
 }
 
 func logMsg(level int, msg string, args ...interface{}) {
@@ -125,7 +135,7 @@ func logMsg(level int, msg string, args ...interface{}) {
 	}
 
 	if len(args) == 0 {
-		Logger.Log(level, lr, DefaultLogCallDepth+1)
+		std().Log(level, lr, GetLogCallDepth()+1)
 		return
 	}
 	switch len(args) {
@@ -136,7 +146,27 @@ func logMsg(level int, msg string, args ...interface{}) {
 		lr.Tag = fmt.Sprintf("%v", args[1])
 		fallthrough
 	case 1:
-		lr.ExcInfo = fmt.Sprintf("%v", args[0])
+		if err, ok := args[0].(error); ok {
+			lr.Err = err
+		} else {
+			lr.ExcInfo = fmt.Sprintf("%v", args[0])
+		}
 	}
-	Logger.Log(level, lr, DefaultLogCallDepth+1)
+	std().Log(level, lr, GetLogCallDepth()+1)
+}
+
+// logErr 直接传入 error 时，message 为错误信息，exc_info 输出错误类型和完整的错误链
+func logErr(level int, err error, args ...interface{}) {
+	lr := &LogRecord{
+		Message: errorMessage(err),
+		Err:     err,
+	}
+	switch len(args) {
+	case 2:
+		lr.TraceId = fmt.Sprintf("%v", args[1])
+		fallthrough
+	case 1:
+		lr.Tag = fmt.Sprintf("%v", args[0])
+	}
+	std().Log(level, lr, GetLogCallDepth()+1)
 }
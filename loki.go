@@ -0,0 +1,215 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 默认作为 Loki stream label 的字段，trace_id 等高基数字段保留在日志行中
+var defaultLokiLabels = []string{"@global_tag", "level_name", "tag"}
+
+// LokiConfig Grafana Loki push 接口的配置
+type LokiConfig struct {
+	Url          string            `json:"url,omitempty" yaml:"url,omitempty"`                     // Loki 地址，如 http://192.168.26.100:3100
+	Labels       []string          `json:"labels,omitempty" yaml:"labels,omitempty"`               // 作为 stream label 的记录字段，默认 @global_tag、level_name、tag
+	StaticLabels map[string]string `json:"static_labels,omitempty" yaml:"static_labels,omitempty"` // 固定 label，如 env=prod
+	TenantId     string            `json:"tenant_id,omitempty" yaml:"tenant_id,omitempty"`         // 多租户时的 X-Scope-OrgID
+	Username     string            `json:"username,omitempty" yaml:"username,omitempty"`           // basic auth 用户名
+	Password     string            `json:"password,omitempty" yaml:"password,omitempty"`           // basic auth 密码
+	BatchSize    int               `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`       // 批发条数，默认 1000
+	Linger       int64             `json:"linger,omitempty" yaml:"linger,omitempty"`               // 延时等待时间(秒)，默认 3
+	Timeout      int               `json:"timeout,omitempty" yaml:"timeout,omitempty"`             // 请求超时时间(毫秒)，默认 3000
+	BufferPath   string            `json:"buffer_path,omitempty" yaml:"buffer_path,omitempty"`     // 发送失败时的缓存目录，默认 /data/loki_buffer
+}
+
+// LokiHandle 批量推送到 Loki 的输出，批量和缓存文件重发逻辑与 SysLogHandle 相同
+type LokiHandle struct {
+	conf   LokiConfig
+	client *http.Client
+	batch  *batchWriter
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+type lokiEntry struct {
+	ts   int64
+	line string
+}
+
+// DialLoki 创建 Loki 输出
+func DialLoki(conf LokiConfig) (*LokiHandle, error) {
+	if conf.Url == "" {
+		return nil, errors.New("loki url is empty")
+	}
+	conf.Url = strings.TrimSuffix(conf.Url, "/")
+	if len(conf.Labels) == 0 {
+		conf.Labels = defaultLokiLabels
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = 3000
+	}
+	if conf.BufferPath == "" {
+		conf.BufferPath = "/data/loki_buffer"
+	}
+	L := &LokiHandle{
+		conf:   conf,
+		client: &http.Client{Timeout: time.Duration(conf.Timeout) * time.Millisecond},
+	}
+	var err error
+	L.batch, err = newBatchWriter("loki", conf.BufferPath, conf.BatchSize, conf.Linger, L.send)
+	if err != nil {
+		return nil, err
+	}
+	L.batch.start()
+	return L, nil
+}
+
+func (L *LokiHandle) Write(b []byte) (n int, err error) {
+	return L.WriteString(string(b))
+}
+
+func (L *LokiHandle) WriteString(msg string) (n int, err error) {
+	if !strings.HasSuffix(msg, "\n") {
+		msg = msg + "\n"
+	}
+	L.batch.put(msg)
+	return len(msg), nil
+}
+
+func (L *LokiHandle) Close() error {
+	L.batch.close()
+	return nil
+}
+
+func (L *LokiHandle) flushBatch(timeout time.Duration) {
+	L.batch.flush(timeout)
+}
+
+// send 把一批记录按 label 分组后推送，服务端限流或异常时返回错误，整批写入缓存文件
+func (L *LokiHandle) send(b []byte) error {
+	body, err := json.Marshal(map[string]interface{}{"streams": L.streams(splitLines(b))})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, L.conf.Url+"/loki/api/v1/push", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if L.conf.TenantId != "" {
+		req.Header.Set("X-Scope-OrgID", L.conf.TenantId)
+	}
+	if L.conf.Username != "" {
+		req.SetBasicAuth(L.conf.Username, L.conf.Password)
+	}
+	resp, err := L.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return fmt.Errorf("loki push status %d: %s", resp.StatusCode, content)
+	}
+	if resp.StatusCode/100 != 2 {
+		// 时间戳过旧、乱序等错误无法通过重试解决，直接丢弃
+		fmt.Fprintln(os.Stderr, "loki drop records", resp.StatusCode, string(content))
+	}
+	return nil
+}
+
+// streams 按 label 把记录分组，每个 stream 内按时间排序
+func (L *LokiHandle) streams(lines [][]byte) []lokiStream {
+	groups := make(map[string]map[string]string)
+	entries := make(map[string][]lokiEntry)
+	var keys []string
+	for _, line := range lines {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal(line, &record); err != nil {
+			fmt.Fprintln(os.Stderr, "loki drop invalid record", err)
+			continue
+		}
+		labels := L.labels(record)
+		key := lokiLabelKey(labels)
+		if _, ok := groups[key]; !ok {
+			groups[key] = labels
+			keys = append(keys, key)
+		}
+		entries[key] = append(entries[key], lokiEntry{
+			ts:   parseLogTime(record["log_time"]).UnixNano(),
+			line: string(line),
+		})
+	}
+
+	streams := make([]lokiStream, 0, len(keys))
+	for _, key := range keys {
+		list := entries[key]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].ts < list[j].ts
+		})
+		stream := lokiStream{Stream: groups[key]}
+		for _, e := range list {
+			stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.ts, 10), e.line})
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+func (L *LokiHandle) labels(record map[string]interface{}) map[string]string {
+	labels := make(map[string]string, len(L.conf.Labels)+len(L.conf.StaticLabels))
+	for k, v := range L.conf.StaticLabels {
+		labels[lokiLabelName(k)] = v
+	}
+	for _, field := range L.conf.Labels {
+		v, ok := record[field]
+		if !ok || v == nil {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			labels[lokiLabelName(field)] = s
+		} else {
+			labels[lokiLabelName(field)] = fmt.Sprintf("%v", v)
+		}
+	}
+	return labels
+}
+
+// lokiLabelName label 名只能包含字母、数字和下划线，且不能以数字开头
+func lokiLabelName(field string) string {
+	name := []byte(strings.TrimLeft(field, "@"))
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+func lokiLabelKey(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[k]))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
@@ -1,25 +1,50 @@
 package navi_go_log
 
 import (
-	"fmt"
+	"io"
+	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 )
 
 var (
-	Logger       *CustomLogger // 控制台日志
-	GlobalConf   LoggerConfig  // 全局配置
-	mySysHandler *SysLogHandle // syslog.writer
+	Logger     *CustomLogger // root_logger，包初始化时赋值，SetDefault 不会修改它。包级函数当前使用的 logger 见 Default
+	GlobalConf LoggerConfig  // 全局配置
 )
 
+// defaultLogger 包级函数使用的 logger，SetDefault 可以在其他协程写日志时替换
+var defaultLogger atomic.Value
+
 type LoggerConfig struct {
-	ToStdout        bool   // 是否输出到控制台
-	StdoutFormat    string // 控制台输出格式（json或custom）
-	SimpleLogStatus bool   // 是否开启简易日志
-	ToElastic       bool   // 是否输出到syslog服务器
-	LogLevel        string // 日志输出等级
-	LogServerIp     string // syslog服务器IP
-	LogServerPort   string // syslog服务器端口
-	LoggerName      string // logger名称，也即服务标签名，如data_transfer
+	ToStdout        bool              `json:"to_stdout,omitempty" yaml:"to_stdout,omitempty"`             // 是否输出到控制台
+	StdoutFormat    string            `json:"stdout_format,omitempty" yaml:"stdout_format,omitempty"`     // 控制台输出格式（json或custom）
+	SimpleLogStatus bool              `json:"simple_log,omitempty" yaml:"simple_log,omitempty"`           // 是否开启简易日志
+	ToElastic       bool              `json:"to_syslog,omitempty" yaml:"to_syslog,omitempty"`             // 是否输出到syslog服务器
+	LogLevel        string            `json:"log_level,omitempty" yaml:"log_level,omitempty"`             // 日志输出等级
+	LogServerIp     string            `json:"log_server_ip,omitempty" yaml:"log_server_ip,omitempty"`     // syslog服务器IP
+	LogServerPort   string            `json:"log_server_port,omitempty" yaml:"log_server_port,omitempty"` // syslog服务器端口
+	Syslog          SyslogConfig      `json:"syslog,omitempty" yaml:"syslog,omitempty"`                   // syslog批量发送和缓存配置，Addr和Level不生效，使用LogServerIp、LogServerPort和LogLevel
+	LoggerName      string            `json:"logger_name,omitempty" yaml:"logger_name,omitempty"`         // logger名称，也即服务标签名，如data_transfer
+	ToGelf          bool              `json:"to_gelf,omitempty" yaml:"to_gelf,omitempty"`                 // 是否输出到GELF(Graylog)
+	Gelf            GelfConfig        `json:"gelf,omitempty" yaml:"gelf,omitempty"`                       // GELF输出配置
+	ToElasticBulk   bool              `json:"to_elastic_bulk,omitempty" yaml:"to_elastic_bulk,omitempty"` // 是否直接写入Elasticsearch的_bulk接口，不经过rsyslog
+	ElasticBulk     ElasticConfig     `json:"elastic_bulk,omitempty" yaml:"elastic_bulk,omitempty"`       // Elasticsearch _bulk 输出配置
+	ToLoki          bool              `json:"to_loki,omitempty" yaml:"to_loki,omitempty"`                 // 是否推送到Grafana Loki
+	Loki            LokiConfig        `json:"loki,omitempty" yaml:"loki,omitempty"`                       // Loki输出配置
+	ToHttp          bool              `json:"to_http,omitempty" yaml:"to_http,omitempty"`                 // 是否以NDJSON格式POST到HTTP收集服务
+	Http            HttpConfig        `json:"http,omitempty" yaml:"http,omitempty"`                       // HTTP输出配置
+	StackFormat     string            `json:"stack_format,omitempty" yaml:"stack_format,omitempty"`       // 栈信息格式：string(默认)、json(stack_frames数组)或both
+	StackDepth      int               `json:"stack_depth,omitempty" yaml:"stack_depth,omitempty"`         // 栈信息最大深度，默认32
+	StackPolicy     *StackPolicy      `json:"stack_policy,omitempty" yaml:"stack_policy,omitempty"`       // 栈信息采集策略，nil时CRITICAL及以上等级采集
+	MetadataFields  []string          `json:"metadata_fields,omitempty" yaml:"metadata_fields,omitempty"` // 每条记录附加的进程和运行环境字段，如hostname、pid，all表示全部
+	Levels          map[string]string `json:"levels,omitempty" yaml:"levels,omitempty"`                   // 按logger名称覆盖日志等级，如{"root_logger": "INFO", "db": "DEBUG"}
+	Sinks           []SinkConfig      `json:"sinks,omitempty" yaml:"sinks,omitempty"`                     // 任意数量的输出，见SinkConfig
+	EnvPrefix       string            `json:"env_prefix,omitempty" yaml:"env_prefix,omitempty"`           // 环境变量前缀，如MYAPP_时优先读取MYAPP_LOG_LEVEL，再读取LOG_LEVEL
+	SinkInstance    string            `json:"sink_instance,omitempty" yaml:"sink_instance,omitempty"`     // 输出实例名，配置相同的输出在实例名相同的logger之间共享，不同时各自创建连接和缓存
+	Propagate       bool              `json:"propagate,omitempty" yaml:"propagate,omitempty"`             // 非root logger的记录写入自己的输出后是否继续交给上级的输出
+
+	fileValues map[string]string // LoadConfig 从配置文件读到的值，用于判断配置项来源
 }
 
 var syslogLevM = map[string]Priority{
@@ -28,63 +53,188 @@ var syslogLevM = map[string]Priority{
 	"ERROR":    LOG_ERR,
 	"WARNING":  LOG_WARNING,
 	"CRITICAL": LOG_CRIT,
+	"FATAL":    LOG_ALERT,
 	"FIXED":    LOG_ALERT,
 }
 
-var defaultLevM = map[string]int{
-	"DEBUG":    DEBUG,
-	"INFO":     INFO,
-	"WARNING":  WARNING,
-	"ERROR":    ERROR,
-	"CRITICAL": CRITICAL,
-	"FIXED":    FIXED,
-}
-
 //============================
 // 初始化
 func init() {
-	// 默认参数
-	GlobalConf.ToStdout = true
-	GlobalConf.ToElastic = false
-	GlobalConf.LogLevel = "INFO"
-	GlobalConf.LoggerName = "log_test"
-	GlobalConf.StdoutFormat = "json"
-	GlobalConf.SimpleLogStatus = false
-
-	Logger = GetLogger(RootLoggerName, DefaultTag)
-	//Logger.SetWriter([]io.Writer{os.Stdout}) // 初始化时默认添加os.Stdout
+	GlobalConf = defaultGlobalConf()
+	Logger = newRootLogger()
+	SetDefault(Logger)
+}
+
+// defaultGlobalConf 默认参数
+func defaultGlobalConf() LoggerConfig {
+	return LoggerConfig{
+		ToStdout:        true,
+		ToElastic:       false,
+		LogLevel:        "INFO",
+		LoggerName:      "log_test",
+		StdoutFormat:    "json",
+		SimpleLogStatus: false,
+	}
+}
+
+// newRootLogger 创建并注册 root_logger
+func newRootLogger() *CustomLogger {
+	root := GetLogger(RootLoggerName, DefaultTag)
+	// 初始化前输出到 os.Stdout，与 GlobalConf 的默认配置一致，下级 logger 通过层级使用它
+	root.SetWriter([]io.Writer{os.Stdout})
+	return root
+}
+
+// std 返回包级函数使用的 logger
+func std() *CustomLogger {
+	return defaultLogger.Load().(*CustomLogger)
+}
+
+// Default 返回包级函数(nLog.Info、nLog.Fatal 等)当前使用的 logger，可以在其他协程调用 SetDefault 时读取
+func Default() *CustomLogger {
+	return std()
+}
+
+// SetDefault 替换包级函数使用的 logger，返回原来的 logger，传入 nil 时恢复为 root_logger。
+// Logger 变量不会随之修改，请使用 Default 获取当前的 logger
+func SetDefault(logger *CustomLogger) *CustomLogger {
+	if logger == nil {
+		logger = GetLogger(RootLoggerName, "")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	old, _ := defaultLogger.Load().(*CustomLogger)
+	defaultLogger.Store(logger)
+	return old
+}
+
+// ListLoggers 按名称排序返回 loggerManager 中所有 logger 的名称
+func ListLoggers() []string {
+	lock.RLock()
+	names := make([]string, 0, len(loggerManager))
+	for name := range loggerManager {
+		names = append(names, name)
+	}
+	lock.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// RemoveLogger 从 loggerManager 中移除 logger，等待它的异步写入结束后关闭它的输出，
+// 下级 logger 改为挂在它的上级下。root_logger 和不存在的名称返回 false。
+// 移除后请不要继续使用该 logger，需要时重新 GetLogger
+func RemoveLogger(name string) bool {
+	if name == RootLoggerName {
+		return false
+	}
+	lock.Lock()
+	logger, ok := loggerManager[name]
+	if ok {
+		delete(loggerManager, name)
+		parent := logger.Parent()
+		for _, child := range loggerManager {
+			if child.Parent() == logger {
+				child.update(func(st *loggerState) { st.parent = parent })
+			}
+		}
+	}
+	lock.Unlock()
+	if !ok {
+		return false
+	}
+	if std() == logger {
+		SetDefault(nil)
+	}
+	logger.Flush()
+	logger.WriterClose()
+	return true
+}
+
+// ResetForTests 关闭并移除所有 logger，恢复 GlobalConf 的默认参数、默认 Logger、
+// FatalPolicy、退出函数和退出钩子，用于测试之间互不影响：
+//
+//	defer nLog.ResetForTests()
+//
+// 调用后需要重新获取 Logger 和 GetLogger 返回的 logger
+func ResetForTests() {
+	lock.Lock()
+	loggers := make([]*CustomLogger, 0, len(loggerManager))
+	for _, logger := range loggerManager {
+		loggers = append(loggers, logger)
+	}
+	loggerManager = make(map[string]*CustomLogger, 1)
+	GlobalConf = defaultGlobalConf()
+	lock.Unlock()
+	for _, logger := range loggers {
+		logger.Flush()
+		logger.WriterClose()
+	}
+
+	// 测试之间调用，不能与写日志并发
+	Logger = newRootLogger()
+	SetDefault(Logger)
+	SetFatalPolicy(FatalPolicy{})
+	SetExitFunc(nil)
+	exitHooks.Lock()
+	exitHooks.hooks = nil
+	exitHooks.Unlock()
 }
 
 var loggerManager = make(map[string]*CustomLogger, 1)
 
 var lock = sync.RWMutex{}
 
-// GetLogger 获取 Logger
+// GetLogger 获取 Logger，名称用点分隔层级，如 orders.db。
+// 新建的 logger 没有自己的等级和输出，使用上级的等级、输出和默认字段，GlobalConf.Levels 中有该名称时使用其中的等级
 func GetLogger(name string, tag string) *CustomLogger {
 	lock.RLock()
 	logger, ok := loggerManager[name]
 	lock.RUnlock()
 	if !ok {
 		lock.Lock()
-		defer lock.Unlock()
-		logger = &CustomLogger{
-			Level:     defaultLevM[GlobalConf.LogLevel],
-			FixedFlag: true,
-			mu:        &sync.Mutex{},
-			GlobalTag: GlobalConf.LoggerName,
-		}
-		logger.Name = name
-		if logger.Name != RootLoggerName {
-			err := logger.InitLogger(&GlobalConf)
-			if err != nil {
-				fmt.Println("InitLogger err:", err.Error())
-			}
+		if logger, ok = loggerManager[name]; !ok {
+			logger = newLogger(name)
+			linkParent(logger)
+			loggerManager[name] = logger
 		}
-
-		loggerManager[name] = logger
+		lock.Unlock()
 	}
 	if tag != "" {
 		logger.SetDefaultTag(tag)
 	}
 	return logger
 }
+
+// globalConf 返回 GlobalConf 的副本，InitLogger 可能同时在修改 GlobalConf
+func globalConf() LoggerConfig {
+	lock.RLock()
+	defer lock.RUnlock()
+	return GlobalConf
+}
+
+// newLogger 创建 logger，调用时需持有 lock
+func newLogger(name string) *CustomLogger {
+	level, _ := ParseLevel(GlobalConf.LogLevel)
+	st := loggerState{
+		level:     int(level),
+		fixed:     true,
+		globalTag: GlobalConf.LoggerName,
+		propagate: true,
+	}
+	if name == RootLoggerName {
+		// root_logger 是层级的顶端，总是使用自己的等级和输出
+		st.configured = true
+		st.levelSet = true
+	} else if override, ok := GlobalConf.Levels[name]; ok {
+		if level, err := ParseLevel(override); err == nil {
+			st.level, st.levelSet = int(level), true
+		}
+	}
+	logger := &CustomLogger{
+		Name:    name,
+		mu:      &sync.Mutex{},
+		pending: newInflight(),
+	}
+	newState(logger, st)
+	return logger
+}
@@ -0,0 +1,217 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 构建时通过 ldflags 注入的版本信息，BuildVersion 优先于 debug.ReadBuildInfo 的主模块版本，
+// BuildCommit 优先于 Go 1.18 起构建信息中的 vcs.revision：
+//
+//	go build -ldflags "-X github.com/yeanguzhou/navi-go-log.BuildVersion=v1.2.3 -X github.com/yeanguzhou/navi-go-log.BuildCommit=abc1234"
+var (
+	BuildVersion string
+	BuildCommit  string
+)
+
+// 可选的进程和运行环境字段
+const (
+	MetaHostname    = "hostname"     // 主机名
+	MetaPid         = "pid"          // 进程号
+	MetaGoroutineId = "goroutine_id" // 协程号，每条记录单独获取
+	MetaVersion     = "version"      // 程序版本，BuildVersion 或主模块版本
+	MetaCommit      = "commit"       // 代码提交，BuildCommit 或 vcs.revision
+	MetaGoVersion   = "go_version"   // Go 版本
+	MetaContainerId = "container_id" // 容器 id，从 /proc/self/cgroup 解析
+	MetaPodName     = "pod_name"     // Kubernetes pod 名，环境变量 POD_NAME
+	MetaNamespace   = "namespace"    // Kubernetes 命名空间，环境变量 POD_NAMESPACE
+	MetaNodeName    = "node_name"    // Kubernetes 节点名，环境变量 NODE_NAME
+	MetaAll         = "all"          // 以上全部字段
+)
+
+var allMetaFields = []string{
+	MetaHostname, MetaPid, MetaGoroutineId, MetaVersion, MetaCommit,
+	MetaGoVersion, MetaContainerId, MetaPodName, MetaNamespace, MetaNodeName,
+}
+
+var NoMatchMetaField = fmt.Errorf("can't match metadata field, available: %s", strings.Join(allMetaFields, ","))
+
+// metaField 编码好的元数据字段，logger 初始化时计算一次
+type metaField struct {
+	name  string
+	value []byte
+}
+
+var (
+	processMetaOnce sync.Once
+	processMeta     map[string]string
+)
+
+// loadProcessMetadata 获取进程运行期间不变的字段，只计算一次，值为空的字段不输出
+func loadProcessMetadata() map[string]string {
+	processMetaOnce.Do(func() {
+		meta := map[string]string{
+			MetaPid:       strconv.Itoa(os.Getpid()),
+			MetaGoVersion: runtime.Version(),
+			MetaPodName:   os.Getenv("POD_NAME"),
+			MetaNamespace: os.Getenv("POD_NAMESPACE"),
+			MetaNodeName:  os.Getenv("NODE_NAME"),
+		}
+		meta[MetaHostname], _ = os.Hostname()
+		meta[MetaVersion], meta[MetaCommit] = buildVersion()
+		if content, err := ioutil.ReadFile("/proc/self/cgroup"); err == nil {
+			meta[MetaContainerId] = containerIdFromCgroup(string(content))
+		}
+		processMeta = meta
+	})
+	return processMeta
+}
+
+// buildVersion 返回程序版本和代码提交，没有构建信息时只使用 ldflags 注入的值
+func buildVersion() (version, commit string) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return BuildVersion, BuildCommit
+	}
+	return versionFromBuildInfo(info)
+}
+
+// versionFromBuildInfo ldflags 注入的版本和 commit 优先，否则使用构建信息中的主模块版本和 vcs.revision。
+// vcs.revision 需要 Go 1.18，更早的版本见 buildinfo_fallback.go
+func versionFromBuildInfo(info *debug.BuildInfo) (version, commit string) {
+	version, commit = BuildVersion, BuildCommit
+	if version == "" && info.Main.Version != "(devel)" {
+		version = info.Main.Version
+	}
+	if commit == "" {
+		commit = vcsRevision(info)
+	}
+	return
+}
+
+// 容器 id 为 64 位十六进制，如 /docker/<id>、/kubepods/.../cri-containerd-<id>.scope
+var containerIdRegexp = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// containerIdFromCgroup 从 /proc/self/cgroup 的内容中解析容器 id，不在容器中时返回空
+func containerIdFromCgroup(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		// 格式为 hierarchy-ID:controller-list:cgroup-path
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if id := containerIdRegexp.FindString(parts[2]); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// goroutineId 从 runtime.Stack 的第一行 "goroutine 18 [running]:" 解析当前协程号
+func goroutineId() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// SetMetadataFields 设置每条记录附加的进程和运行环境字段，如 hostname、pid，MetaAll 表示全部字段。
+// 传入空列表时不附加。
+func (logger *CustomLogger) SetMetadataFields(fields []string) error {
+	meta, withGoroutine, err := metadataFields(fields)
+	if err != nil {
+		return err
+	}
+	logger.update(func(st *loggerState) {
+		st.metaFields, st.metaGoroutine = meta, withGoroutine
+		st.overrides |= fieldMetadata
+	})
+	return nil
+}
+
+// metadataFields 解析字段名，返回编码好的元数据字段和是否附加 goroutine_id
+func metadataFields(fields []string) ([]metaField, bool, error) {
+	var names []string
+	for _, f := range fields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "" {
+			continue
+		}
+		if f == MetaAll {
+			names = allMetaFields
+			break
+		}
+		if !isMetaField(f) {
+			return nil, false, NoMatchMetaField
+		}
+		names = append(names, f)
+	}
+
+	var meta []metaField
+	withGoroutine := false
+	for _, name := range names {
+		if name == MetaGoroutineId {
+			withGoroutine = true
+			continue
+		}
+		value := loadProcessMetadata()[name]
+		if value == "" {
+			continue
+		}
+		if name == MetaPid {
+			meta = append(meta, metaField{name, []byte(value)})
+		} else {
+			meta = append(meta, metaField{name, EncodeString(value, false)})
+		}
+	}
+	return meta, withGoroutine, nil
+}
+
+func isMetaField(name string) bool {
+	for _, f := range allMetaFields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// writeMetadata 写入元数据字段，拓展字段中有同名字段时以拓展字段为准
+func (st *loggerState) writeMetadata(data *bytes.Buffer, extra *ExtField) {
+	for _, f := range st.metaFields {
+		if extra != nil {
+			if _, ok := (*extra)[f.name]; ok {
+				continue
+			}
+		}
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString(f.name)
+		data.WriteString(`":`)
+		data.Write(f.value)
+	}
+	if st.metaGoroutine {
+		if extra != nil {
+			if _, ok := (*extra)[MetaGoroutineId]; ok {
+				return
+			}
+		}
+		data.WriteByte(',')
+		data.WriteByte('"')
+		data.WriteString(MetaGoroutineId)
+		data.WriteString(`":`)
+		data.WriteString(strconv.FormatInt(goroutineId(), 10))
+	}
+}
@@ -0,0 +1,97 @@
+package navi_go_log
+
+import (
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
+	"unicode"
+)
+
+var (
+	// 主模块的导入路径，如 github.com/yeanguzhou/navi-go-log
+	mainModulePath string
+	// 依赖模块在模块缓存中的目录名，如 github.com/pkg/errors@v0.9.1/
+	depModuleDirs []string
+	// 主模块源码根目录，第一次遇到主模块的栈帧时推断出来，类型为 string
+	mainModuleRoot atomic.Value
+)
+
+func init() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	mainModulePath = info.Main.Path
+	for _, dep := range info.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		if dep.Version == "" {
+			// 替换为本地目录的模块无法确定根目录
+			continue
+		}
+		depModuleDirs = append(depModuleDirs, escapeModulePath(dep.Path)+"@"+dep.Version+"/")
+	}
+}
+
+// TrimModulePath 把源文件路径转换成相对模块根目录的路径：
+// 主模块的文件返回相对路径，如 internal/order/db.go；
+// 依赖模块的文件返回模块缓存中的路径，如 github.com/pkg/errors@v0.9.1/errors.go；
+// 标准库的文件返回相对 GOROOT/src 的路径，如 net/http/server.go。
+// funcFullName 为该文件中的函数全名，用来推断主模块的根目录。无法识别时退回到 StripGOPATH
+func TrimModulePath(file, funcFullName string) string {
+	file = filepath.ToSlash(file)
+	if root := moduleRoot(file, funcFullName); root != "" && strings.HasPrefix(file, root) {
+		return file[len(root):]
+	}
+	// 使用 -trimpath 编译时，主模块的文件以模块路径开头
+	if mainModulePath != "" && strings.HasPrefix(file, mainModulePath+"/") {
+		return file[len(mainModulePath)+1:]
+	}
+	for _, dir := range depModuleDirs {
+		if i := strings.Index(file, dir); i >= 0 {
+			return file[i:]
+		}
+	}
+	if gorootSrc != "" && strings.HasPrefix(file, gorootSrc) {
+		return file[len(gorootSrc):]
+	}
+	return StripGOPATH(file)
+}
+
+// moduleRoot 返回主模块根目录(以 / 结尾)，还未推断出来时用当前栈帧推断
+func moduleRoot(file, funcFullName string) string {
+	if root, ok := mainModuleRoot.Load().(string); ok {
+		return root
+	}
+	if mainModulePath == "" || mainModulePath == "command-line-arguments" {
+		return ""
+	}
+	pkg := funcPackage(funcFullName)
+	if pkg != mainModulePath && !strings.HasPrefix(pkg, mainModulePath+"/") {
+		return ""
+	}
+	// 包路径相对模块路径的部分应该与文件目录的结尾一致
+	rel := strings.TrimPrefix(pkg, mainModulePath)
+	dir := filepath.ToSlash(filepath.Dir(file))
+	if !strings.HasSuffix(dir, rel) || !filepath.IsAbs(file) {
+		return ""
+	}
+	root := dir[:len(dir)-len(rel)] + "/"
+	mainModuleRoot.Store(root)
+	return root
+}
+
+// escapeModulePath 模块缓存中大写字母会转义为 ! 加小写字母
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
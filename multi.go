@@ -0,0 +1,58 @@
+package navi_go_log
+
+import (
+	"bytes"
+	"context"
+)
+
+type stacksKey struct{}
+
+// ContextWithStacks 返回携带调用栈 m 的 context。
+// LogRecord.Context 为该 context 时，m 中的调用栈会和日志调用处的栈一起输出。
+func ContextWithStacks(ctx context.Context, m *Multi) context.Context {
+	return context.WithValue(ctx, stacksKey{}, m)
+}
+
+// ContextWithCallers 在 ctx 已携带的调用栈后追加当前调用栈，不修改 ctx 中原有的 Multi。
+// 用于跨协程传递时记录经过的位置，如生产者放入 channel 前调用。
+// skip 为 0 时从 ContextWithCallers 的调用者开始。
+func ContextWithCallers(ctx context.Context, skip int) context.Context {
+	m := new(Multi)
+	if old := StacksFromContext(ctx); old != nil {
+		m = old.Copy()
+	}
+	m.AddCallers(skip + 1)
+	return ContextWithStacks(ctx, m)
+}
+
+// StacksFromContext 返回 ctx 携带的调用栈，没有时返回 nil
+func StacksFromContext(ctx context.Context) *Multi {
+	if ctx == nil {
+		return nil
+	}
+	m, _ := ctx.Value(stacksKey{}).(*Multi)
+	return m
+}
+
+// recordStacks 返回日志记录携带的调用栈，LogRecord.Stacks 优先于 LogRecord.Context
+func recordStacks(logRecord *LogRecord) *Multi {
+	if logRecord.Stacks != nil && len(logRecord.Stacks.stacks) > 0 {
+		return logRecord.Stacks
+	}
+	if m := StacksFromContext(logRecord.Context); m != nil && len(m.stacks) > 0 {
+		return m
+	}
+	return nil
+}
+
+// writeMultiJSON 输出为栈数组的数组，形如 [[{"func":"main","file":"main.go","line":10}],[...]]
+func writeMultiJSON(b *bytes.Buffer, m *Multi) {
+	b.WriteByte('[')
+	for i, s := range m.stacks {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		writeStackJSON(b, s)
+	}
+	b.WriteByte(']')
+}
@@ -0,0 +1,231 @@
+package navi_go_log
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ObservedEntry 观察者捕获到的一条日志记录
+type ObservedEntry struct {
+	LoggerName  string
+	Level       int
+	LevelName   string
+	Time        time.Time
+	Message     string
+	Tag         string
+	TraceId     string
+	ExcInfo     string
+	Err         error
+	StackInfo   string
+	Fingerprint string
+	Extra       ExtField
+	Filename    string
+	Module      string
+	FuncName    string
+	LineNo      int
+}
+
+// ObservedLogs 内存中的日志观察者，用于在单元测试中断言日志输出。
+// 记录在 Log 调用时同步捕获，不经过输出协程。
+type ObservedLogs struct {
+	mu      sync.RWMutex
+	entries []ObservedEntry
+}
+
+// NewObserver 创建一个空的日志观察者
+func NewObserver() *ObservedLogs {
+	return &ObservedLogs{}
+}
+
+// NewObservedLogger 创建一个只输出到观察者的 logger，不会写控制台或 syslog。
+// 返回的 logger 不注册到 loggerManager。
+func NewObservedLogger(level int) (*CustomLogger, *ObservedLogs) {
+	observer := NewObserver()
+	if _, ok := levelInfo(Level(level)); !ok {
+		level = INFO
+	}
+	logger := &CustomLogger{
+		Name:    "observer",
+		mu:      &sync.Mutex{},
+		pending: newInflight(),
+	}
+	newState(logger, loggerState{
+		level:      level,
+		levelSet:   true,
+		fixed:      true,
+		globalTag:  globalConf().LoggerName,
+		observer:   observer,
+		configured: true,
+	})
+	return logger, observer
+}
+
+func (o *ObservedLogs) add(entry ObservedEntry) {
+	o.mu.Lock()
+	o.entries = append(o.entries, entry)
+	o.mu.Unlock()
+}
+
+// Len 返回捕获到的记录条数
+func (o *ObservedLogs) Len() int {
+	o.mu.RLock()
+	n := len(o.entries)
+	o.mu.RUnlock()
+	return n
+}
+
+// All 按写入顺序返回所有记录的副本
+func (o *ObservedLogs) All() []ObservedEntry {
+	o.mu.RLock()
+	entries := make([]ObservedEntry, len(o.entries))
+	copy(entries, o.entries)
+	o.mu.RUnlock()
+	return entries
+}
+
+// TakeAll 返回所有记录并清空观察者
+func (o *ObservedLogs) TakeAll() []ObservedEntry {
+	o.mu.Lock()
+	entries := o.entries
+	o.entries = nil
+	o.mu.Unlock()
+	return entries
+}
+
+// Reset 清空已捕获的记录，一般在每个测试开始前调用
+func (o *ObservedLogs) Reset() {
+	o.mu.Lock()
+	o.entries = nil
+	o.mu.Unlock()
+}
+
+// Filter 返回满足条件的记录组成的新观察者
+func (o *ObservedLogs) Filter(keep func(e ObservedEntry) bool) *ObservedLogs {
+	filtered := &ObservedLogs{}
+	for _, e := range o.All() {
+		if keep(e) {
+			filtered.entries = append(filtered.entries, e)
+		}
+	}
+	return filtered
+}
+
+// FilterLevel 过滤出指定等级的记录
+func (o *ObservedLogs) FilterLevel(level int) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		return e.Level == level
+	})
+}
+
+// FilterLevelAbove 过滤出等级大于等于 level 的记录
+func (o *ObservedLogs) FilterLevelAbove(level int) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		return e.Level >= level
+	})
+}
+
+// FilterMessage 过滤出 message 完全相同的记录
+func (o *ObservedLogs) FilterMessage(msg string) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		return e.Message == msg
+	})
+}
+
+// FilterMessageSnippet 过滤出 message 包含指定片段的记录
+func (o *ObservedLogs) FilterMessageSnippet(snippet string) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		return strings.Contains(e.Message, snippet)
+	})
+}
+
+// FilterTag 过滤出指定 tag 的记录
+func (o *ObservedLogs) FilterTag(tag string) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		return e.Tag == tag
+	})
+}
+
+// FilterTraceId 过滤出指定 trace_id 的记录
+func (o *ObservedLogs) FilterTraceId(traceId string) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		return e.TraceId == traceId
+	})
+}
+
+// FilterExtra 过滤出拓展字段 key 的值等于 value 的记录
+func (o *ObservedLogs) FilterExtra(key string, value interface{}) *ObservedLogs {
+	return o.Filter(func(e ObservedEntry) bool {
+		v, ok := e.Extra[key]
+		return ok && reflect.DeepEqual(v, value)
+	})
+}
+
+// SetObserver 为 logger 挂载观察者，传入 nil 时卸载。Propagate 为 true 的下级的记录也会交给它
+func (logger *CustomLogger) SetObserver(observer *ObservedLogs) {
+	logger.update(func(st *loggerState) { st.observer = observer })
+}
+
+// observers 返回记录需要交给的观察者：自己和 Propagate 链上各个上级的观察者，与 outputs 相同
+func (st *loggerState) observers() []*ObservedLogs {
+	var observers []*ObservedLogs
+	for s := st; s != nil; s = s.parentState() {
+		if s.observer != nil && !containsObserver(observers, s.observer) {
+			observers = append(observers, s.observer)
+		}
+		if !s.propagate {
+			break
+		}
+	}
+	return observers
+}
+
+func containsObserver(observers []*ObservedLogs, observer *ObservedLogs) bool {
+	for _, o := range observers {
+		if o == observer {
+			return true
+		}
+	}
+	return false
+}
+
+// observe 同步记录一条日志到自己和上级的观察者，同一个观察者只记录一次
+func (logger *CustomLogger) observe(st *loggerState, level int, logRecord *LogRecord, stackInfo, fingerprint, filename, module, funcName string, lineNo int) {
+	observers := st.observers()
+	if len(observers) == 0 {
+		return
+	}
+	entry := ObservedEntry{
+		LoggerName:  logger.Name,
+		Level:       level,
+		LevelName:   Level(level).String(),
+		Time:        time.Now(),
+		Message:     logRecord.Message,
+		Tag:         logRecord.Tag,
+		TraceId:     logRecord.TraceId,
+		ExcInfo:     logRecord.ExcInfo,
+		Err:         logRecord.Err,
+		StackInfo:   stackInfo,
+		Fingerprint: fingerprint,
+		Filename:    filename,
+		Module:      module,
+		FuncName:    funcName,
+		LineNo:      lineNo,
+	}
+	if entry.Tag == "" {
+		entry.Tag = st.tagName
+	}
+	if entry.ExcInfo == "" && entry.Err != nil {
+		entry.ExcInfo = errorMessage(entry.Err)
+	}
+	if logRecord.Extra != nil {
+		entry.Extra = make(ExtField, len(*logRecord.Extra))
+		for k, v := range *logRecord.Extra {
+			entry.Extra[k] = v
+		}
+	}
+	for _, o := range observers {
+		o.add(entry)
+	}
+}
@@ -0,0 +1,83 @@
+package navi_go_log
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// callerPCs 传给 Log 的调用栈，代替 Log 自己获取的调用位置和栈信息
+type callerPCs []uintptr
+
+// RecoverAndLog 捕获 panic 并以 CRITICAL 等级记录，栈信息为发生 panic 的协程调用栈。
+// panic 的值可以是任意类型，repanic 为 true 时等待日志写出(包括批量输出的缓存队列)后重新 panic。
+// 必须直接 defer 调用：
+//
+//	defer logger.RecoverAndLog(false)
+func (logger *CustomLogger) RecoverAndLog(repanic bool) {
+	if r := recover(); r != nil {
+		logger.logPanic(r, repanic)
+	}
+}
+
+// Go 启动一个协程执行 f，f 中的 panic 会被记录而不会导致程序退出
+func (logger *CustomLogger) Go(f func()) {
+	go func() {
+		defer logger.RecoverAndLog(false)
+		f()
+	}()
+}
+
+// RecoverAndLog 使用默认 Logger 记录 panic，必须直接 defer 调用
+func RecoverAndLog(repanic bool) {
+	if r := recover(); r != nil {
+		std().logPanic(r, repanic)
+	}
+}
+
+// Go 使用默认 Logger 启动记录 panic 的协程
+func Go(f func()) {
+	std().Go(f)
+}
+
+func (logger *CustomLogger) logPanic(r interface{}, repanic bool) {
+	record := &LogRecord{
+		Message: fmt.Sprintf("panic: %v", r),
+		Extra:   &ExtField{"panic_type": fmt.Sprintf("%T", r)},
+	}
+	if err, ok := r.(error); ok {
+		record.Err = err
+	} else {
+		record.ExcInfo = fmt.Sprint(r)
+	}
+	logger.Log(CRITICAL, record, panicPCs())
+	if repanic {
+		// 重新 panic 后进程通常会退出，同步发送批量输出的缓存队列，最多等待 FatalPolicy 的 FlushTimeout
+		logger.Flush()
+		logger.flushOutputs(fatalPolicy.Load().(FatalPolicy).flushTimeout())
+		panic(r)
+	}
+}
+
+// panicPCs 获取发生 panic 的协程调用栈，从触发 panic 的函数开始
+func panicPCs() callerPCs {
+	pcs := make([]uintptr, maxStackSize)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+	for i, pc := range pcs {
+		info := resolveCaller(pc)
+		if info == nil || info.funcFullName != "runtime.gopanic" {
+			continue
+		}
+		rest := pcs[i+1:]
+		// 跳过 runtime 中产生 panic 的函数，如空指针的 sigpanic、越界的 goPanicIndex
+		for len(rest) > 1 {
+			info = resolveCaller(rest[0])
+			if info == nil || !strings.HasPrefix(info.funcFullName, "runtime.") {
+				break
+			}
+			rest = rest[1:]
+		}
+		return rest
+	}
+	return pcs
+}
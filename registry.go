@@ -0,0 +1,95 @@
+package navi_go_log
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// sinkEntry 注册表中的一个输出，refs 为持有它的 sharedSink 数量
+type sinkEntry struct {
+	key    string
+	handle LogHandle
+	refs   int
+}
+
+// sinkRegistry 按配置共享的输出，配置相同的 logger 使用同一个连接池和缓存目录
+var sinkRegistry = struct {
+	sync.Mutex
+	entries map[string]*sinkEntry
+}{entries: make(map[string]*sinkEntry)}
+
+// sharedSink 对注册表中输出的一个引用，Close 只释放自己的引用，最后一个引用释放时关闭输出
+type sharedSink struct {
+	entry *sinkEntry
+	once  sync.Once
+}
+
+func (s *sharedSink) Write(b []byte) (n int, err error) {
+	return s.entry.handle.Write(b)
+}
+
+func (s *sharedSink) WriteString(msg string) (n int, err error) {
+	return s.entry.handle.WriteString(msg)
+}
+
+func (s *sharedSink) Close() (err error) {
+	s.once.Do(func() {
+		err = releaseSink(s.entry)
+	})
+	return err
+}
+
+// flushBatch 同步发送共享输出的缓存队列，不影响其他 logger 的引用
+func (s *sharedSink) flushBatch(timeout time.Duration) {
+	if f, ok := s.entry.handle.(batchFlusher); ok {
+		f.flushBatch(timeout)
+	}
+}
+
+// sinkKey 输出的注册表键，instance 不同的相同配置各自创建输出
+func sinkKey(sinkType, instance string, conf interface{}) string {
+	content, _ := json.Marshal(conf)
+	return sinkType + "|" + instance + "|" + string(content)
+}
+
+// acquireSink 获取键对应的输出，不存在时调用 dial 创建
+func acquireSink(key string, dial func() (LogHandle, error)) (*sharedSink, error) {
+	sinkRegistry.Lock()
+	defer sinkRegistry.Unlock()
+	entry, ok := sinkRegistry.entries[key]
+	if !ok {
+		handle, err := dial()
+		if err != nil {
+			return nil, err
+		}
+		entry = &sinkEntry{key: key, handle: handle}
+		sinkRegistry.entries[key] = entry
+	}
+	entry.refs++
+	return &sharedSink{entry: entry}, nil
+}
+
+func releaseSink(entry *sinkEntry) error {
+	sinkRegistry.Lock()
+	entry.refs--
+	if entry.refs > 0 {
+		sinkRegistry.Unlock()
+		return nil
+	}
+	delete(sinkRegistry.entries, entry.key)
+	sinkRegistry.Unlock()
+	// 关闭时会发送剩余的缓存队列，不持有锁
+	return entry.handle.Close()
+}
+
+// sinkRefs 返回当前共享的输出及其引用数，键为输出类型和配置
+func sinkRefs() map[string]int {
+	sinkRegistry.Lock()
+	defer sinkRegistry.Unlock()
+	refs := make(map[string]int, len(sinkRegistry.entries))
+	for key, entry := range sinkRegistry.entries {
+		refs[key] = entry.refs
+	}
+	return refs
+}
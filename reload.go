@@ -0,0 +1,184 @@
+package navi_go_log
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 配置文件的默认检查间隔
+const defaultWatchInterval = 5 * time.Second
+
+// ReloadConfig 把配置重新应用到 loggerManager 中所有的 logger：
+// root_logger 使用新的配置，旧的输出在发送完缓存后关闭。
+// 单独调用过 InitLogger 的下级 logger 保留自己的输出、LoggerName、SinkInstance、Propagate 等配置，
+// 只合并相对上次发生变化的 LogLevel、Levels 和 StdoutFormat；
+// 没有调用过 InitLogger 的下级 logger 只更新 Levels 中的等级，从 Levels 中删除的改为使用上级的等级，
+// 其余沿用上级的配置；不在新旧 Levels 中的 logger 保留代码中用 SetLevel 设置的等级。
+// 配置中没有 LoggerName 时沿用当前的 LoggerName，环境变量仍然优先。
+func ReloadConfig(conf *LoggerConfig) error {
+	rootConf := *conf
+	if rootConf.LoggerName == "" {
+		rootConf.LoggerName = globalConf().LoggerName
+	}
+
+	lock.RLock()
+	loggers := make([]*CustomLogger, 0, len(loggerManager))
+	for _, logger := range loggerManager {
+		loggers = append(loggers, logger)
+	}
+	lock.RUnlock()
+
+	root := GetLogger(RootLoggerName, "")
+	oldRoot := root.lastConfig()
+	if err := root.InitLogger(&rootConf); err != nil {
+		return err
+	}
+	newRoot := root.lastConfig()
+	global := globalConf()
+	var errs []error
+	for _, logger := range loggers {
+		if logger == root {
+			continue
+		}
+		if !logger.load().configured {
+			// 没有单独配置的 logger 继续使用上级的输出，只更新 Levels 中的等级
+			if override, ok := global.Levels[logger.Name]; ok {
+				level, _ := ParseLevel(override)
+				logger.SetLevel(int(level))
+			} else if _, ok := oldRoot.Levels[logger.Name]; ok {
+				// 只取消上次配置设置的等级，代码中用 SetLevel 设置的等级保持不变
+				logger.inheritLevel()
+			}
+			continue
+		}
+		childConf := logger.lastConfig()
+		mergeReloaded(&childConf, &oldRoot, &newRoot)
+		if err := logger.InitLogger(&childConf); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", logger.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("reload config: %v", errs)
+	}
+	return nil
+}
+
+// lastConfig 返回最近一次 InitLogger 应用的配置
+func (logger *CustomLogger) lastConfig() LoggerConfig {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	return logger.conf
+}
+
+// mergeReloaded 把 root_logger 配置中发生变化的全局字段合并到下级 logger 的配置
+func mergeReloaded(conf, oldRoot, newRoot *LoggerConfig) {
+	if newRoot.LogLevel != oldRoot.LogLevel {
+		conf.LogLevel = newRoot.LogLevel
+	}
+	if !reflect.DeepEqual(newRoot.Levels, oldRoot.Levels) {
+		conf.Levels = newRoot.Levels
+	}
+	if newRoot.StdoutFormat != oldRoot.StdoutFormat {
+		conf.StdoutFormat = newRoot.StdoutFormat
+	}
+}
+
+// ConfigWatcher 监视配置文件，文件修改或收到 SIGHUP 时重新加载
+type ConfigWatcher struct {
+	path     string
+	interval time.Duration
+	modTime  time.Time
+	size     int64
+
+	signals  chan os.Signal
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	onReload func(err error)
+}
+
+// WatchConfig 每隔 interval 检查一次配置文件(小于等于0时为5秒)，文件修改时间或大小变化，
+// 或者进程收到 SIGHUP 时，用 LoadConfig 读取并通过 ReloadConfig 应用到所有 logger。
+// onReload 不为 nil 时在每次重新加载后调用，err 不为空表示加载失败，logger 保持原来的配置。
+// WatchConfig 不会立即应用配置，启动时请先用 LoadConfig 和 InitLogger 完成初始化。
+func WatchConfig(path string, interval time.Duration, onReload func(err error)) (*ConfigWatcher, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	w := &ConfigWatcher{
+		path:     path,
+		interval: interval,
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		signals:  make(chan os.Signal, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		onReload: onReload,
+	}
+	signal.Notify(w.signals, syscall.SIGHUP)
+	go w.run()
+	return w, nil
+}
+
+// Stop 停止监视，可以重复调用
+func (w *ConfigWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		signal.Stop(w.signals)
+		close(w.stop)
+	})
+	<-w.done
+}
+
+func (w *ConfigWatcher) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.signals:
+			w.reload()
+		case <-ticker.C:
+			if w.changed() {
+				w.reload()
+			}
+		}
+	}
+}
+
+// changed 配置文件的修改时间或大小是否变化，文件暂时不存在(如正在替换)时视为未变化
+func (w *ConfigWatcher) changed() bool {
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.modTime = info.ModTime()
+	w.size = info.Size()
+	return true
+}
+
+func (w *ConfigWatcher) reload() {
+	conf, err := LoadConfig(w.path)
+	if err == nil {
+		err = ReloadConfig(conf)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reload log config fail", err)
+	}
+	if w.onReload != nil {
+		w.onReload(err)
+	}
+}
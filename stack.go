@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
@@ -24,7 +25,8 @@ import (
 // (function name). All these types implement a pretty human readable String()
 // function.
 //
-// The GOPATH is stripped from the File location. Look at the StripGOPATH
+// The File location is trimmed relative to its module root, see TrimModulePath.
+// For GOPATH builds the GOPATH is stripped instead. Look at the StripGOPATH
 // function on instructions for how to embed to GOPATH into the binary for when
 // deploying to production and the GOPATH environment variable may not be set.
 // The package name is stripped from the Name of the function since it included
@@ -102,13 +104,14 @@ func (m *Multi) Copy() *Multi {
 // Caller returns a single Frame for the caller. The argument skip is the
 // number of stack frames to ascend, with 0 identifying the caller of Callers.
 func Caller(skip int) Frame {
-	pc, file, line, _ := runtime.Caller(skip + 1)
-	fun := runtime.FuncForPC(pc)
-	return Frame{
-		File: StripGOPATH(file),
-		Line: line,
-		Name: StripPackage(fun.Name()),
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return Frame{}
 	}
+	if info := resolveCaller(pcs[0]); info != nil {
+		return info.frame()
+	}
+	return Frame{}
 }
 
 // Callers returns a Stack of Frames for the callers. The argument skip is the
@@ -116,42 +119,63 @@ func Caller(skip int) Frame {
 func Callers(skip int) Stack {
 	pcs := make([]uintptr, maxStackSize)
 	num := runtime.Callers(skip+2, pcs)
-	stack := make(Stack, num)
-	for i, pc := range pcs[:num] {
-		fun := runtime.FuncForPC(pc)
-		file, line := fun.FileLine(pc - 1)
-		stack[i].File = StripGOPATH(file)
-		stack[i].Line = line
-		stack[i].Name = StripPackage(fun.Name())
+	return stackFromPCs(pcs[:num])
+}
+
+// stackFromPCs 把 runtime.Callers 得到的返回地址转换成 Stack
+func stackFromPCs(pcs []uintptr) Stack {
+	stack := make(Stack, 0, len(pcs))
+	for _, pc := range pcs {
+		if info := resolveCaller(pc); info != nil {
+			stack = append(stack, info.frame())
+		}
 	}
 	return stack
 }
 
 func CallersWithFirstCallInfo(skip int) (stackInfo string, fileName, module, funcFullName string, lineNo int) {
-	pcs := make([]uintptr, maxStackSize)
+	var stack Stack
+	stack, fileName, module, funcFullName, lineNo = callersWithFirstCallInfo(skip+1, maxStackSize, false)
+	stackInfo = stack.String()
+	return
+}
+
+// callersWithFirstCallInfo 获取最多 depth 层的调用栈，同时返回第一层调用的文件名、包名、函数名和行号。
+// skipStd 为 true 时跳过 runtime 和标准库的栈帧
+func callersWithFirstCallInfo(skip, depth int, skipStd bool) (stack Stack, fileName, module, funcFullName string, lineNo int) {
+	if depth <= 0 {
+		depth = maxStackSize
+	}
+	extra := 0
+	if hasHelpers() {
+		extra = maxHelperDepth
+	}
+	pcs := make([]uintptr, depth+extra)
 	num := runtime.Callers(skip+2, pcs)
-	stack := make(Stack, num)
-	for i, pc := range pcs[:num] {
-		fun := runtime.FuncForPC(pc)
-		file, line := fun.FileLine(pc - 1)
-		stack[i].File = StripGOPATH(file)
-		stack[i].Line = line
-		stack[i].Name = StripPackage(fun.Name())
+	return framesWithFirstCallInfo(skipHelperPCs(pcs[:num]), depth, skipStd)
+}
+
+// framesWithFirstCallInfo 把程序计数器转换成最多 depth 层的调用栈，同时返回第一层调用的信息
+func framesWithFirstCallInfo(pcs []uintptr, depth int, skipStd bool) (stack Stack, fileName, module, funcFullName string, lineNo int) {
+	if depth > 0 && len(pcs) > depth {
+		pcs = pcs[:depth]
+	}
+	stack = make(Stack, 0, len(pcs))
+	for i, pc := range pcs {
+		info := resolveCaller(pc)
+		if info == nil {
+			continue
+		}
 		if i == 0 {
-			lineNo = line
-			funcFullName = fun.Name()
-			// 获取文件名
-			_, fileName = filepath.Split(file)
-
-			slashI := strings.Index(funcFullName, ".")
-			if slashI == -1 {
-				module = funcFullName
-			} else {
-				module = funcFullName[:slashI]
-			}
+			lineNo = info.line
+			fileName = info.fileName
+			module = info.module
+			funcFullName = info.funcName
+		} else if skipStd && info.std {
+			continue
 		}
+		stack = append(stack, info.frame())
 	}
-	stackInfo = stack.String()
 	return
 }
 
@@ -190,6 +214,24 @@ func writeStack(b *bytes.Buffer, s Stack) {
 	}
 }
 
+// writeStackJSON 把栈信息写成 json 数组，如 [{"func":"main","file":"main.go","line":10}]
+func writeStackJSON(b *bytes.Buffer, s Stack) {
+	b.WriteByte('[')
+	for i, f := range s {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`{"func":`)
+		b.Write(EncodeString(f.Name, false))
+		b.WriteString(`,"file":`)
+		b.Write(EncodeString(f.File, false))
+		b.WriteString(`,"line":`)
+		b.WriteString(strconv.Itoa(f.Line))
+		b.WriteByte('}')
+	}
+	b.WriteByte(']')
+}
+
 func numDigits(i int) int {
 	var n int
 	for {
@@ -251,6 +293,19 @@ func SetGOPATH(gp string) {
 	gopaths = append(gopaths, filepath.Join(runtime.GOROOT(), "src", "pkg")+"/")
 }
 
+// funcPackage 返回函数全名中的包路径，如 github.com/a/b.(*T).M 返回 github.com/a/b
+func funcPackage(n string) string {
+	slashI := strings.LastIndex(n, "/")
+	if slashI == -1 {
+		slashI = 0 // for built-in packages
+	}
+	dotI := strings.Index(n[slashI:], ".")
+	if dotI == -1 {
+		return n
+	}
+	return n[:slashI+dotI]
+}
+
 // StripPackage strips the package name from the given Func.Name.
 func StripPackage(n string) string {
 	slashI := strings.LastIndex(n, "/")
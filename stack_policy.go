@@ -0,0 +1,75 @@
+package navi_go_log
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// StackPolicy 栈信息采集策略，零值等同于 DefaultStackPolicy
+type StackPolicy struct {
+	Level                Level `json:"level,omitempty" yaml:"level,omitempty"`                                     // 大于等于该等级时采集栈信息(FIXED 除外)，可以写等级名或数字，小于等于0时为 CRITICAL
+	OnExcInfo            bool  `json:"on_exc_info,omitempty" yaml:"on_exc_info,omitempty"`                         // 设置了 ExcInfo 或 Err 时也采集栈信息
+	MaxDepth             int   `json:"max_depth,omitempty" yaml:"max_depth,omitempty"`                             // 栈信息最大深度，小于等于0时为32
+	SkipRuntime          bool  `json:"skip_runtime,omitempty" yaml:"skip_runtime,omitempty"`                       // 跳过 runtime 和标准库的栈帧
+	AllGoroutinesOnFatal bool  `json:"all_goroutines_on_fatal,omitempty" yaml:"all_goroutines_on_fatal,omitempty"` // FATAL 时 stack_info 输出所有协程的栈信息
+}
+
+// DefaultStackPolicy 默认策略：CRITICAL 及以上等级采集最多32层栈信息
+func DefaultStackPolicy() StackPolicy {
+	return StackPolicy{
+		Level:    CRITICAL,
+		MaxDepth: maxStackSize,
+	}
+}
+
+// SetStackPolicy 设置 logger 的栈信息采集策略
+func (logger *CustomLogger) SetStackPolicy(policy StackPolicy) {
+	logger.update(func(st *loggerState) {
+		st.stackPolicy = policy
+		st.overrides |= fieldStackPolicy
+	})
+}
+
+// needStack 判断本条日志是否需要采集栈信息，level 为 FIXED 时不记录
+func (policy *StackPolicy) needStack(level int, logRecord *LogRecord) bool {
+	if level == FIXED {
+		return false
+	}
+	threshold := policy.Level
+	if threshold <= 0 {
+		threshold = CRITICAL
+	}
+	if Level(level) >= threshold {
+		return true
+	}
+	return policy.OnExcInfo && (logRecord.ExcInfo != "" || logRecord.Err != nil)
+}
+
+// 标准库源码目录，如 /usr/local/go/src/。使用 -trimpath 编译时为空
+var gorootSrc = func() string {
+	pc := reflect.ValueOf(strings.Index).Pointer()
+	fun := runtime.FuncForPC(pc)
+	if fun == nil {
+		return ""
+	}
+	file, _ := fun.FileLine(pc)
+	if i := strings.LastIndex(file, "/strings/"); i >= 0 {
+		return file[:i+1]
+	}
+	return ""
+}()
+
+// isStdFrame 判断栈帧是否属于 runtime 或标准库
+func isStdFrame(funcFullName, file string) bool {
+	if strings.HasPrefix(funcFullName, "runtime.") {
+		return true
+	}
+	if gorootSrc != "" {
+		return strings.HasPrefix(file, gorootSrc)
+	}
+	// -trimpath 编译时按包路径判断，标准库的第一段路径不包含 "."
+	pkg := funcPackage(funcFullName)
+	first := strings.SplitN(pkg, "/", 2)[0]
+	return pkg != "main" && !strings.Contains(first, ".")
+}
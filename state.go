@@ -0,0 +1,130 @@
+package navi_go_log
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// loggerState 写日志时读取的配置。修改时在 mu 保护下复制一份再整体替换，
+// 写日志的协程只做一次原子读取，不需要加锁，也不会读到修改了一半的配置
+type loggerState struct {
+	level         int
+	levelSet      bool // 是否设置过等级，没有时使用上级的等级
+	fixed         bool // 是否启用 FIXED 日志输出
+	out           io.Writer
+	customStdout  io.Writer
+	tag           []byte
+	tagName       string // 未编码的默认TAG
+	globalTag     string
+	stdoutFormat  string
+	simpleLog     bool
+	stackFormat   string        // 栈信息输出格式
+	stackPolicy   StackPolicy   // 栈信息采集策略
+	metaFields    []metaField   // 附加的进程和运行环境字段
+	metaGoroutine bool          // 是否附加 goroutine_id
+	observer      *ObservedLogs // 测试用的日志观察者
+	parent        *CustomLogger // 上级 logger，见 hierarchy.go
+	configured    bool          // 是否调用过 InitLogger，没有时使用上级的输出和默认字段
+	overrides     fieldSet      // 没有调用 InitLogger 时单独设置过的字段
+	propagate     bool          // 记录是否继续交给上级的输出
+	pending       *inflight
+}
+
+// newState 创建 logger 的初始状态
+func newState(logger *CustomLogger, st loggerState) {
+	st.pending = logger.pending
+	logger.state = &atomic.Value{}
+	logger.state.Store(&st)
+	logger.initFields(&st)
+}
+
+func (logger *CustomLogger) load() *loggerState {
+	return logger.state.Load().(*loggerState)
+}
+
+// update 复制当前状态，由 f 修改后整体替换。f 在 mu 保护下执行
+func (logger *CustomLogger) update(f func(st *loggerState)) {
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	st := *logger.load()
+	f(&st)
+	logger.state.Store(&st)
+}
+
+// initFields 在创建 logger 时填充为兼容保留的导出字段，之后不再修改，避免与读取这些字段的协程竞争
+func (logger *CustomLogger) initFields(st *loggerState) {
+	logger.Level = st.level
+	logger.FixedFlag = st.fixed
+	logger.Tag = st.tag
+	logger.GlobalTag = st.globalTag
+	logger.StdoutFormat = st.stdoutFormat
+	logger.SimpleLogStatus = st.simpleLog
+}
+
+// GetLevel 返回 logger 自己设置的等级，没有设置过时为创建时的默认值。实际生效的等级见 EffectiveLevel
+func (logger *CustomLogger) GetLevel() int {
+	return logger.load().level
+}
+
+// GetFixedFlag 是否启用 FIXED 日志输出
+func (logger *CustomLogger) GetFixedFlag() bool {
+	return logger.load().fixed
+}
+
+// GetTag 返回默认 TAG
+func (logger *CustomLogger) GetTag() string {
+	return logger.load().tagName
+}
+
+// GetGlobalTag 返回 Global Tag
+func (logger *CustomLogger) GetGlobalTag() string {
+	return logger.load().globalTag
+}
+
+// GetStdoutFormat 返回控制台输出格式，json 或 custom
+func (logger *CustomLogger) GetStdoutFormat() string {
+	return logger.load().stdoutFormat
+}
+
+// GetSimpleLogStatus 是否启用简易日志输出
+func (logger *CustomLogger) GetSimpleLogStatus() bool {
+	return logger.load().simpleLog
+}
+
+// inflight 未完成的异步写入计数。与 sync.WaitGroup 不同，Wait 的同时可以继续 Add，
+// 其他协程一直在写日志时 Flush 也是安全的
+type inflight struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	n    int
+}
+
+func newInflight() *inflight {
+	w := &inflight{}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+func (w *inflight) Add(delta int) {
+	w.mu.Lock()
+	w.n += delta
+	if w.n <= 0 {
+		w.n = 0
+		w.cond.Broadcast()
+	}
+	w.mu.Unlock()
+}
+
+func (w *inflight) Done() {
+	w.Add(-1)
+}
+
+// Wait 等待计数归零
+func (w *inflight) Wait() {
+	w.mu.Lock()
+	for w.n > 0 {
+		w.cond.Wait()
+	}
+	w.mu.Unlock()
+}
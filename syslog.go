@@ -1,15 +1,11 @@
 package navi_go_log
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"math/rand"
 	"net"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -86,19 +82,13 @@ func (s *sysConn) isOld() bool {
 type SysLogHandle struct {
 	priority Priority //等级
 	addr     string   //连接地址
-	daemon   bool     //后台
-	stopTag  chan int //发送协程
-
-	netPool *queue // 连接池
-	buff    *queue //缓存队列
-
-	limit     chan int
-	waitGroup sync.WaitGroup //并发控制
-	filePath  string         //缓存文件
-	batchSize int            // 批发条数
-	linger    int64          //延时等待时间
-	timeout   time.Duration  //发送超时时间
-	lifeTime  int64          //连接最大生存时间
+
+	netPool *queue       // 连接池
+	batch   *batchWriter // 缓存队列及批量发送
+
+	timeout   time.Duration //发送超时时间
+	lifeTime  int64         //连接最大生存时间
+	closeOnce sync.Once
 }
 
 func (S *SysLogHandle) Write(b []byte) (n int, err error) {
@@ -111,34 +101,22 @@ func (S *SysLogHandle) WriteString(msg string) (n int, err error) {
 	}
 	pri := LOG_LOCAL0 + S.priority
 	message := fmt.Sprintf("<%d>%s", pri, msg)
-	S.buff.Put(message)
+	S.batch.put(message)
 	return len(msg), nil
 }
 
+// Close 发送完缓存队列后关闭连接，可以重复调用
 func (S *SysLogHandle) Close() error {
-	S.daemon = false
-	<-S.stopTag
-	count := 0
-	buff := new(bytes.Buffer)
-	for !S.buff.Empty() {
-		content, ok := S.buff.Get()
-		if ok {
-			buff.WriteString(content.(string))
-			count++
-		}
-		if count >= S.batchSize {
-			S.waitGroup.Add(1)
-			S.emit(buff.Bytes())
-			buff.Reset()
-			count = 0
-		}
-	}
-	if buff.Len() > 0 {
-		S.waitGroup.Add(1)
-		S.emit(buff.Bytes())
-	}
+	S.closeOnce.Do(S.close)
+	return nil
+}
+
+func (S *SysLogHandle) flushBatch(timeout time.Duration) {
+	S.batch.flush(timeout)
+}
 
-	S.waitGroup.Wait() //等待所有发送结束
+func (S *SysLogHandle) close() {
+	S.batch.close()
 	for !S.netPool.Empty() {
 		conn, ok := S.netPool.Get()
 		if !ok { // have no connect to use
@@ -150,36 +128,7 @@ func (S *SysLogHandle) Close() error {
 		}
 		connect.conn.Close()
 	}
-	S.buff.Close()
 	S.netPool.Close()
-	return nil
-}
-
-func (S *SysLogHandle) scanBuffer() {
-	defer func() {
-		S.stopTag <- 1
-	}()
-	start := time.Now().Unix()
-	count := 0
-	for S.daemon {
-		buff := new(bytes.Buffer)
-		for count < S.batchSize && time.Now().Unix()-start < S.linger {
-			content, ok := S.buff.Get()
-			if ok {
-				buff.WriteString(content.(string))
-				count++
-			}
-		}
-		if count > 0 {
-			S.waitGroup.Add(1)
-			go S.emit(buff.Bytes())
-		}
-		if count < S.batchSize {
-			S.scanFile()
-		}
-		count = 0
-		start = time.Now().Unix()
-	}
 }
 
 func (S *SysLogHandle) getConn() *sysConn {
@@ -208,73 +157,73 @@ func (S *SysLogHandle) getConn() *sysConn {
 	return c
 }
 
-func (S *SysLogHandle) emit(b []byte) {
-	defer S.waitGroup.Add(-1)
-	select {
-	case S.limit <- 1:
-		defer func() {
-			<-S.limit
-		}()
-		conn := S.getConn()
-		if conn == nil {
-			S.writeFile(b)
-			return
-		}
-		conn.timeout()
-		_, err := conn.conn.Write(b)
-
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "syslog send fail, write file", err)
-			S.writeFile(b)
-			conn.conn.Close()
-			return
-		}
-		S.netPool.Put(conn)
+func (S *SysLogHandle) send(b []byte) error {
+	conn := S.getConn()
+	if conn == nil {
+		return errors.New("syslog has no connect to use")
+	}
+	conn.timeout()
+	_, err := conn.conn.Write(b)
 
-	case <-time.After(time.Millisecond * 10):
-		fmt.Fprintln(os.Stderr, "flow control, write file")
-		S.writeFile(b)
+	if err != nil {
+		conn.conn.Close()
+		return err
 	}
-	return
+	S.netPool.Put(conn)
+	return nil
 }
 
-func (S *SysLogHandle) init() {
-	filePath, ok := os.LookupEnv("SYSLOG_BUFFER")
-	if !ok {
-		filePath = "/data/syslog_buffer"
-	}
-	S.filePath = strings.TrimSuffix(filePath, "/")
+// SyslogConfig syslog 输出配置，未设置的项使用环境变量或默认值
+type SyslogConfig struct {
+	Addr         string `json:"addr,omitempty" yaml:"addr,omitempty"`                     // rsyslog 地址，如 192.168.26.100:514，只支持 tcp
+	Level        string `json:"level,omitempty" yaml:"level,omitempty"`                   // 决定 syslog 优先级的日志等级，默认使用 LogLevel
+	BatchSize    int    `json:"batch_size,omitempty" yaml:"batch_size,omitempty"`         // 批发条数，环境变量 SYSLOG_BATCH_SIZE(兼容 BATCH_SIZE)，默认 1000
+	Linger       int64  `json:"linger,omitempty" yaml:"linger,omitempty"`                 // 延时等待时间(秒)，环境变量 SYSLOG_LINGER(兼容 Linger)，默认 3
+	Timeout      int    `json:"timeout,omitempty" yaml:"timeout,omitempty"`               // 发送超时时间(毫秒)，环境变量 SYSLOG_TIMEOUT，默认 3000
+	ConnLifeTime int64  `json:"conn_life_time,omitempty" yaml:"conn_life_time,omitempty"` // 连接最大生存时间(秒)，环境变量 SYSLOG_CONN_LIFE_TIME，默认 100
+	BufferPath   string `json:"buffer_path,omitempty" yaml:"buffer_path,omitempty"`       // 发送失败时的缓存目录，环境变量 SYSLOG_BUFFER，默认 /data/syslog_buffer
+}
 
-	batchSize, ok := os.LookupEnv("BATCH_SIZE")
-	if !ok {
-		batchSize = "1000"
+// withEnvDefaults 未设置的项使用环境变量，环境变量也未设置时使用默认值
+func (conf SyslogConfig) withEnvDefaults() SyslogConfig {
+	env := LoggerConfig{}
+	bindEnv(&env, "syslog.")
+	for _, s := range settings {
+		if strings.HasPrefix(s.key, "syslog.") && (s.get(&env) == "" || s.get(&env) == "0") {
+			s.set(&env, s.def)
+		}
 	}
-	S.batchSize, _ = strconv.Atoi(batchSize)
 
-	Linger, ok := os.LookupEnv("Linger")
-	if !ok {
-		Linger = "3"
+	if conf.BufferPath == "" {
+		conf.BufferPath = env.Syslog.BufferPath
 	}
-	S.linger, _ = strconv.ParseInt(Linger, 10, 64)
-
-	timeOut, ok := os.LookupEnv("SYSLOG_TIMEOUT")
-	if !ok {
-		timeOut = "3000"
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = env.Syslog.BatchSize
 	}
-	timeout, _ := strconv.Atoi(timeOut)
-	S.timeout = time.Duration(timeout)
-
-	lifeTime, ok := os.LookupEnv("SYSLOG_CONN_LIFE_TIME")
-	if !ok {
-		lifeTime = "100"
+	if conf.Linger <= 0 {
+		conf.Linger = env.Syslog.Linger
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = env.Syslog.Timeout
 	}
-	S.lifeTime, _ = strconv.ParseInt(lifeTime, 10, 64)
-	err := os.MkdirAll(S.filePath, os.ModePerm)
+	if conf.ConnLifeTime <= 0 {
+		conf.ConnLifeTime = env.Syslog.ConnLifeTime
+	}
+	return conf
+}
+
+func (S *SysLogHandle) init(conf SyslogConfig) error {
+	S.timeout = time.Duration(conf.Timeout)
+	S.lifeTime = conf.ConnLifeTime
+
+	var err error
+	S.batch, err = newBatchWriter("syslog", conf.BufferPath, conf.BatchSize, conf.Linger, S.send)
 	if err != nil {
-		panic(err)
+		return err
 	}
 	S.createConn()
-	go S.scanBuffer()
+	S.batch.start()
+	return nil
 }
 
 func (S *SysLogHandle) createConn() {
@@ -296,51 +245,31 @@ func Dial(network, addr string, priority Priority) (*SysLogHandle, error) {
 		return nil, errors.New("syslog only support tcp")
 	}
 
-	w := &SysLogHandle{
-		priority: priority,
-		addr:     addr,
-		netPool:  NewQueue(30, time.Millisecond*10),
-		buff:     NewQueue(100000, time.Millisecond*10),
-		daemon:   true,
-		stopTag:  make(chan int),
-		limit:    make(chan int, 30),
-	}
-	w.init()
-	return w, nil
+	return dialSyslog(SyslogConfig{Addr: addr}, priority)
 }
 
-func (S *SysLogHandle) writeFile(data []byte) {
-	//f := getRandomString(32)
-	fileName := fmt.Sprintf("%s/%d", S.filePath, time.Now().UnixNano())
-	err := ioutil.WriteFile(fileName, data, os.ModePerm)
+// DialSyslog 按配置创建 syslog 输出，优先级由 conf.Level 决定，默认 INFO
+func DialSyslog(conf SyslogConfig) (*SysLogHandle, error) {
+	if conf.Addr == "" {
+		return nil, errors.New("syslog addr is empty")
+	}
+	level, err := ParseLevel(conf.Level)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+		return nil, err
 	}
-	return
+	return dialSyslog(conf, levelPriority(level.String()))
 }
 
-func (S *SysLogHandle) scanFile() {
-	filePath := S.filePath
-	files, err := ioutil.ReadDir(filePath)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		return
-	}
-	if len(files) == 0 {
-		return
-	}
-	n := rand.Intn(len(files))
-	name := files[n].Name()
-	fileName := filePath + "/" + name
-	content, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
+func dialSyslog(conf SyslogConfig, priority Priority) (*SysLogHandle, error) {
+	w := &SysLogHandle{
+		priority: priority,
+		addr:     conf.Addr,
+		netPool:  NewQueue(30, time.Millisecond*10),
 	}
-	if len(content) > 0 {
-		S.waitGroup.Add(1)
-		go S.emit(content)
+	if err := w.init(conf.withEnvDefaults()); err != nil {
+		return nil, err
 	}
-	os.Remove(fileName)
+	return w, nil
 }
 
 //func getRandomString(length int) string {
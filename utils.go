@@ -2,10 +2,9 @@ package navi_go_log
 
 import (
 	"io"
-	"path/filepath"
 	"runtime"
-	"strings"
 	"sync"
+	"time"
 	"unicode/utf8"
 )
 
@@ -25,31 +24,39 @@ func GetLockWriter(w io.Writer, lock *sync.Mutex) io.Writer {
 	return &Stdio{w, lock}
 }
 
-// 获取函数调用信息
+// 获取函数调用信息，解析结果按程序计数器缓存
 func setFuncInfo(skip int) (fileName, module, funcFullName string, lineNo int) {
 	fileName = "NIL"
 	funcFullName = "NIL"
 	module = "NIL"
 	lineNo = -1
 
-	pc, file, lineNo, ok := runtime.Caller(skip)
-	if !ok {
+	// 没有辅助函数时只需要一层调用栈
+	var pcs [1 + maxHelperDepth]uintptr
+	n := 1
+	if hasHelpers() {
+		n = len(pcs)
+	}
+	n = runtime.Callers(skip+1, pcs[:n])
+	if n == 0 {
 		return
 	}
-	// 设置函数名和包名
-
-	funcFullName = runtime.FuncForPC(pc).Name() // 900+ ns/op
-
-	// 获取文件名
-	_, fileName = filepath.Split(file)
+	info := resolveCaller(skipHelperPCs(pcs[:n])[0])
+	if info == nil {
+		return
+	}
+	// module 为完整的包导入路径，函数名去掉包路径
+	return info.fileName, info.module, info.funcName, info.line
+}
 
-	slashI := strings.Index(funcFullName, ".")
-	if slashI == -1 {
-		module = funcFullName
-	} else {
-		module = funcFullName[:slashI]
+// parseLogTime 解析 Log 输出的 log_time 字段，解析失败时返回当前时间
+func parseLogTime(v interface{}) time.Time {
+	if s, ok := v.(string); ok {
+		if t, err := time.ParseInLocation(RFC3339, s, time.Local); err == nil {
+			return t
+		}
 	}
-	return
+	return time.Now()
 }
 
 // 获取调用栈信息
@@ -0,0 +1,164 @@
+package navi_go_log
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 日志等级的常用别名
+var levelAliases = map[string]string{
+	"WARN":  "WARNING",
+	"ERR":   "ERROR",
+	"CRIT":  "CRITICAL",
+	"PANIC": "FATAL",
+}
+
+// ParseLevel 把等级名解析成日志等级，不区分大小写，支持 WARN、ERR、CRIT、PANIC 等别名和数字等级，
+// 如 "info"、"Warn"、"40"。空字符串为 INFO
+func ParseLevel(name string) (Level, error) {
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	if upper == "" {
+		return INFO, nil
+	}
+	if alias, ok := levelAliases[upper]; ok {
+		upper = alias
+	}
+	table := loadLevels()
+	if level, ok := table.byName[upper]; ok {
+		return level, nil
+	}
+	if n, err := strconv.Atoi(upper); err == nil {
+		if _, ok := table.byLevel[Level(n)]; ok {
+			return Level(n), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", NoMatchLogLevel, name)
+}
+
+// ConfigErrors LoggerConfig 校验发现的所有错误
+type ConfigErrors []error
+
+func (errs ConfigErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, err := range errs {
+		msgs[i] = err.Error()
+	}
+	return "invalid logger config: " + strings.Join(msgs, "; ")
+}
+
+// Validate 检查配置，返回 ConfigErrors，包含所有发现的问题：
+// 无法识别的日志等级、syslog 地址和端口、各输出缺少地址、未知的输出类型和元数据字段等
+func (conf *LoggerConfig) Validate() error {
+	var errs ConfigErrors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, err := ParseLevel(conf.LogLevel); err != nil {
+		add("log_level: %v", err)
+	}
+	for name, level := range conf.Levels {
+		if _, err := ParseLevel(level); err != nil {
+			add("levels[%s]: %v", name, err)
+		}
+	}
+	switch conf.StackFormat {
+	case "", StackFormatString, StackFormatJson, StackFormatBoth:
+	default:
+		add("stack_format: unknown format %q, use string, json or both", conf.StackFormat)
+	}
+	for _, f := range conf.MetadataFields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != "" && f != MetaAll && !isMetaField(f) {
+			add("metadata_fields: %v: %q", NoMatchMetaField, f)
+		}
+	}
+
+	if conf.ToElastic {
+		if conf.LogServerIp == "" {
+			add("log_server_ip: empty while to_syslog is enabled")
+		}
+		if port, err := strconv.Atoi(conf.LogServerPort); err != nil || port <= 0 || port > 65535 {
+			add("log_server_port: invalid port %q", conf.LogServerPort)
+		}
+	}
+	if conf.ToGelf && conf.Gelf.Addr == "" {
+		add("gelf.addr: empty while to_gelf is enabled")
+	}
+	if conf.ToElasticBulk && conf.ElasticBulk.Url == "" {
+		add("elastic_bulk.url: empty while to_elastic_bulk is enabled")
+	}
+	if conf.ToLoki && conf.Loki.Url == "" {
+		add("loki.url: empty while to_loki is enabled")
+	}
+	if conf.ToHttp && conf.Http.Url == "" {
+		add("http.url: empty while to_http is enabled")
+	}
+
+	for i, sink := range conf.Sinks {
+		if err := sink.validate(); err != nil {
+			add("sinks[%d]: %v", i, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate 检查输出类型和必填的地址，不建立连接
+func (sink SinkConfig) validate() error {
+	missing := fmt.Errorf("%s sink has no %s config", sink.Type, sink.Type)
+	switch sink.Type {
+	case SinkSyslog:
+		if sink.Syslog == nil {
+			return missing
+		}
+		if sink.Syslog.Addr == "" {
+			return fmt.Errorf("syslog addr is empty")
+		}
+		if _, err := ParseLevel(sink.Syslog.Level); err != nil {
+			return err
+		}
+	case SinkFile:
+		if sink.File == nil {
+			return missing
+		}
+		if sink.File.Path == "" {
+			return fmt.Errorf("file path is empty")
+		}
+	case SinkHttp:
+		if sink.Http == nil {
+			return missing
+		}
+		if sink.Http.Url == "" {
+			return fmt.Errorf("http url is empty")
+		}
+	case SinkGelf:
+		if sink.Gelf == nil {
+			return missing
+		}
+		if sink.Gelf.Addr == "" {
+			return fmt.Errorf("gelf addr is empty")
+		}
+	case SinkElastic:
+		if sink.Elastic == nil {
+			return missing
+		}
+		if sink.Elastic.Url == "" {
+			return fmt.Errorf("elastic url is empty")
+		}
+	case SinkLoki:
+		if sink.Loki == nil {
+			return missing
+		}
+		if sink.Loki.Url == "" {
+			return fmt.Errorf("loki url is empty")
+		}
+	default:
+		return fmt.Errorf("unknown sink type %q", sink.Type)
+	}
+	return nil
+}